@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+const (
+	// GOG Galaxy's public client, the only one allowed to redirect to
+	// on_login_success.
+	clientId     = "46899977096215655"
+	clientSecret = "9d85c43b1482497dbbce61f6e4aa173a433796eeae2ca8c5f6129f2dc4de46d9"
+	redirectUri  = "https://embed.gog.com/on_login_success?origin=client"
+	embedUrl     = "https://embed.gog.com"
+	// Refresh this long before the access token actually expires.
+	tokenLeeway = 5 * 60
+)
+
+// Vars so they can be pointed at a fake auth server.
+var (
+	authUrl  = "https://auth.gog.com/auth"
+	tokenUrl = "https://auth.gog.com/token"
+)
+
+// Sent as a bearer token to GOG hosts once loaded, and refreshed by
+// getAccessToken when it's about to expire.
+var (
+	curToken     *Token
+	curTokenPath string
+	tokenMutex   sync.Mutex
+)
+
+// Token requests skip Transport, which would try to refresh for them.
+var authClient = &http.Client{Timeout: 30 * time.Second}
+
+func getAuthUrl() string {
+	query := url.Values{}
+	query.Set("client_id", clientId)
+	query.Set("redirect_uri", redirectUri)
+	query.Set("response_type", "code")
+	query.Set("layout", "client2")
+	return authUrl + "?" + query.Encode()
+}
+
+// Takes either the bare code or the whole URL GOG redirected to.
+func parseAuthCode(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if !strings.Contains(input, "code=") {
+		if input == "" {
+			return "", errors.New("no code")
+		}
+		return input, nil
+	}
+	u, err := url.Parse(input)
+	if err != nil {
+		return "", err
+	}
+	code := u.Query().Get("code")
+	if code == "" {
+		return "", errors.New("no code in url")
+	}
+	return code, nil
+}
+
+func requestToken(query url.Values) (*Token, error) {
+	query.Set("client_id", clientId)
+	query.Set("client_secret", clientSecret)
+	req, err := authClient.Get(tokenUrl + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+	if req.StatusCode != http.StatusOK {
+		return nil, errors.New(req.Status)
+	}
+
+	var obj Token
+	err = json.NewDecoder(req.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	if obj.AccessToken == "" || obj.RefreshToken == "" {
+		return nil, errors.New("token response is missing tokens")
+	}
+	obj.ExpiresAt = time.Now().Unix() + obj.ExpiresIn
+	return &obj, nil
+}
+
+func exchangeCode(code string) (*Token, error) {
+	query := url.Values{}
+	query.Set("grant_type", "authorization_code")
+	query.Set("code", code)
+	query.Set("redirect_uri", redirectUri)
+	return requestToken(query)
+}
+
+func refreshToken(token *Token) (*Token, error) {
+	query := url.Values{}
+	query.Set("grant_type", "refresh_token")
+	query.Set("refresh_token", token.RefreshToken)
+	return requestToken(query)
+}
+
+func readToken(tokenPath string) (*Token, error) {
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil, err
+	}
+	var obj Token
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func writeToken(tokenPath string, token *Token) error {
+	data, err := json.MarshalIndent(token, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tokenPath, data, 0600)
+}
+
+func isTokenFresh(token *Token) bool {
+	return token.ExpiresAt-tokenLeeway > time.Now().Unix()
+}
+
+// Returns nil if there's no stored token, in which case cookies are used.
+func loadToken(tokenPath string) (*Token, error) {
+	token, err := readToken(tokenPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if isTokenFresh(token) {
+		return token, nil
+	}
+	token, err = refreshToken(token)
+	if err != nil {
+		return nil, errors.New("failed to refresh token, run login again\n" + err.Error())
+	}
+	err = writeToken(tokenPath, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// The embed host takes bearer tokens for the same endpoints www takes
+// cookies for.
+func useToken(token *Token, tokenPath string) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	curToken = token
+	curTokenPath = tokenPath
+	siteUrl = embedUrl
+}
+
+// Checked on every request, as access tokens only last about an hour and a
+// library sync can take far longer. Empty if signed in with cookies.
+func getAccessToken() (string, error) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	if curToken == nil {
+		return "", nil
+	}
+	if isTokenFresh(curToken) {
+		return curToken.AccessToken, nil
+	}
+	token, err := refreshToken(curToken)
+	if err != nil {
+		return "", errors.New("failed to refresh token, run login again\n" + err.Error())
+	}
+	err = writeToken(curTokenPath, token)
+	if err != nil {
+		return "", err
+	}
+	curToken = token
+	return token.AccessToken, nil
+}
+
+func login(cfg *Config) error {
+	code := cfg.AuthCode
+	if code == "" {
+		fmt.Println("Sign in with the URL below. GOG will redirect to a blank page, " +
+			"paste its URL here.\n" + getAuthUrl())
+		prompt := &survey.Input{Message: "Redirect URL or code:"}
+		err := survey.AskOne(prompt, &code, survey.WithValidator(survey.Required))
+		if err != nil {
+			return err
+		}
+	}
+	code, err := parseAuthCode(code)
+	if err != nil {
+		return err
+	}
+	token, err := exchangeCode(code)
+	if err != nil {
+		return err
+	}
+	err = writeToken(cfg.TokenPath, token)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Signed in. Token saved to " + cfg.TokenPath + ".")
+	return nil
+}
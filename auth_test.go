@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Fake auth server: hands out "access-<n>" / "refresh-<n>" on each grant.
+func newAuthServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var n int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/token" || query.Get("client_id") != clientId ||
+			query.Get("client_secret") != clientSecret {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch query.Get("grant_type") {
+		case "authorization_code":
+			if query.Get("code") != "good-code" || query.Get("redirect_uri") != redirectUri {
+				http.Error(w, "bad code", http.StatusUnauthorized)
+				return
+			}
+		case "refresh_token":
+			if query.Get("refresh_token") != "old-refresh" {
+				http.Error(w, "bad refresh token", http.StatusUnauthorized)
+				return
+			}
+		default:
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		n++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("access-%d", n),
+			"refresh_token": fmt.Sprintf("refresh-%d", n),
+			"expires_in":    3600,
+			"user_id":       "123",
+		})
+	}))
+	oldUrl := tokenUrl
+	tokenUrl = srv.URL + "/token"
+	t.Cleanup(func() {
+		tokenUrl = oldUrl
+		srv.Close()
+	})
+	return srv
+}
+
+func TestParseAuthCode(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"abc123", "abc123", false},
+		{"  abc123\n", "abc123", false},
+		{"https://embed.gog.com/on_login_success?origin=client&code=abc123", "abc123", false},
+		{"https://embed.gog.com/on_login_success?code=abc%2F123&origin=client", "abc/123", false},
+		{"", "", true},
+		{"https://embed.gog.com/on_login_success?origin=client&code=", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseAuthCode(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAuthCode(%q) err = %v, want err %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseAuthCode(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	newAuthServer(t)
+	token, err := exchangeCode("good-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" || token.RefreshToken != "refresh-1" {
+		t.Errorf("got tokens %q / %q", token.AccessToken, token.RefreshToken)
+	}
+	if token.ExpiresAt < time.Now().Unix()+3500 {
+		t.Errorf("ExpiresAt = %d, not set from expires_in", token.ExpiresAt)
+	}
+
+	_, err = exchangeCode("bad-code")
+	if err == nil {
+		t.Error("expected an error for a rejected code")
+	}
+}
+
+func TestLoadTokenRefreshes(t *testing.T) {
+	newAuthServer(t)
+	tokenPath := filepath.Join(t.TempDir(), "token.json")
+	expired := &Token{
+		AccessToken:  "old-access",
+		RefreshToken: "old-refresh",
+		ExpiresAt:    time.Now().Unix() - 60,
+	}
+	err := writeToken(tokenPath, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := loadToken(tokenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" {
+		t.Errorf("AccessToken = %q, want refreshed token", token.AccessToken)
+	}
+	saved, err := readToken(tokenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "access-1" || saved.RefreshToken != "refresh-1" {
+		t.Errorf("saved tokens %q / %q, want refreshed ones written back",
+			saved.AccessToken, saved.RefreshToken)
+	}
+
+	token, err = loadToken(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || token != nil {
+		t.Errorf("missing token file: got %v, %v, want nil, nil", token, err)
+	}
+}
+
+func TestGetAccessTokenRefreshes(t *testing.T) {
+	newAuthServer(t)
+	oldSite := siteUrl
+	defer func() {
+		useToken(nil, "")
+		siteUrl = oldSite
+	}()
+	tokenPath := filepath.Join(t.TempDir(), "token.json")
+	useToken(&Token{
+		AccessToken:  "old-access",
+		RefreshToken: "old-refresh",
+		// Still valid, but inside the leeway.
+		ExpiresAt: time.Now().Unix() + 60,
+	}, tokenPath)
+
+	got, err := getAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "access-1" {
+		t.Errorf("getAccessToken() = %q, want refreshed token", got)
+	}
+	saved, err := readToken(tokenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "access-1" {
+		t.Errorf("saved AccessToken = %q, want refreshed token", saved.AccessToken)
+	}
+
+	// Fresh now, so no second refresh.
+	got, err = getAccessToken()
+	if err != nil || got != "access-1" {
+		t.Errorf("second getAccessToken() = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Wrapped by verifyItem when a file still doesn't match after repairing.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+func getDownlink(manualUrl string) (*Downlink, error) {
+	downlinkUrl := strings.Replace(manualUrl, "/downloads/", "/downlink/", 1)
+	req, err := client.Get(downlinkUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+	if req.StatusCode != http.StatusOK {
+		return nil, errors.New(req.Status)
+	}
+
+	var obj Downlink
+	err = json.NewDecoder(req.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// Returns nil if GOG doesn't publish a checksum for the item, which is the
+// case for most goodies.
+func getChecksum(manualUrl string) (*FileChecksum, error) {
+	downlink, err := getDownlink(manualUrl)
+	if err != nil {
+		return nil, err
+	}
+	if downlink.Checksum == "" {
+		return nil, nil
+	}
+
+	req, err := client.Get(downlink.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+	if req.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if req.StatusCode != http.StatusOK {
+		return nil, errors.New(req.Status)
+	}
+
+	var obj FileChecksum
+	err = xml.NewDecoder(req.Body).Decode(&obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func md5Reader(r io.Reader) (string, error) {
+	hash := md5.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func getBadChunks(fpath string, sum *FileChecksum) ([]*ChunkChecksum, error) {
+	var bad []*ChunkChecksum
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	for _, chunk := range sum.Chunks {
+		if chunk.Method != "md5" {
+			continue
+		}
+		section := io.NewSectionReader(f, chunk.From, chunk.To-chunk.From+1)
+		hash, err := md5Reader(section)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(hash, strings.TrimSpace(chunk.Hash)) {
+			bad = append(bad, chunk)
+		}
+	}
+	return bad, nil
+}
+
+// Checks size, then the whole file hash, falling back to the chunk hashes to
+// find out what's corrupt.
+func checkFile(fpath string, sum *FileChecksum) (bool, []*ChunkChecksum, error) {
+	_, size, err := fileExists(fpath)
+	if err != nil {
+		return false, nil, err
+	}
+	if sum.TotalSize == 0 || size == sum.TotalSize {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return false, nil, err
+		}
+		hash, err := md5Reader(f)
+		f.Close()
+		if err != nil {
+			return false, nil, err
+		}
+		if sum.MD5 == "" || strings.EqualFold(hash, sum.MD5) {
+			return true, nil, nil
+		}
+	}
+	bad, err := getBadChunks(fpath, sum)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, bad, nil
+}
+
+func repairChunks(itemUrl, name, fpath string, sum *FileChecksum, bad []*ChunkChecksum) error {
+	var totalBytes int64
+	for _, chunk := range bad {
+		totalBytes += chunk.To - chunk.From + 1
+	}
+
+	f, err := os.OpenFile(fpath, os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if sum.TotalSize > 0 {
+		err = f.Truncate(sum.TotalSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	var mutex sync.Mutex
+	counter := newCounter(name+" (repair)", totalBytes, 0)
+	defer finishCounter(counter)
+	for _, chunk := range bad {
+		cw := &ChunkWriter{
+			File:    f,
+			Chunk:   &Chunk{Start: chunk.From, End: chunk.To},
+			Counter: counter,
+			Mutex:   &mutex,
+		}
+		err = downloadChunk(itemUrl, cw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verifies a finished download against GOG's checksum manifest and
+// redownloads corrupt chunks once before giving up.
+func verifyItem(itemUrl, name, fpath string) error {
+	sum, err := getChecksum(itemUrl)
+	if err != nil {
+		handleErr("failed to get checksum for "+name+", skipping verification", err, false)
+		return nil
+	}
+	if sum == nil {
+		printLine("No checksum available for " + name + ", skipping verification.")
+		return nil
+	}
+
+	ok, bad, err := checkFile(fpath, sum)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	// Nothing to repair without chunk hashes.
+	if len(bad) == 0 {
+		return fmt.Errorf("%w: %s", errChecksumMismatch, name)
+	}
+
+	printLine(fmt.Sprintf("%d corrupt chunk(s) in %s, redownloading...", len(bad), name))
+	err = repairChunks(itemUrl, name, fpath, sum, bad)
+	if err != nil {
+		return err
+	}
+	ok, bad, err = checkFile(fpath, sum)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s, %d chunk(s) still corrupt", errChecksumMismatch, name, len(bad))
+	}
+	return nil
+}
+
+// A partial that fails its final check is removed, otherwise the next run
+// would take the full size file as a leftover and ask for the bytes after it.
+func verifyPartial(itemUrl, name, incompPath, statePath string) error {
+	err := verifyItem(itemUrl, name, incompPath)
+	if errors.Is(err, errChecksumMismatch) {
+		rmErr := removePartial(incompPath, statePath)
+		if rmErr != nil {
+			handleErr("failed to remove corrupt partial", rmErr, false)
+		}
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake GOG item: /downloads/<name> serves Data, /downlink/<name> points at
+// /checksum/<name>, which serves Checksum if it's set.
+type itemServer struct {
+	*httptest.Server
+	Name     string
+	Data     []byte
+	Checksum string
+	// Answer range requests with the whole file.
+	IgnoreRange bool
+	// Drop the connection once this many bytes of a GET have been sent.
+	CutAfter int64
+
+	mutex  sync.Mutex
+	ranges []string
+}
+
+func newItemServer(t *testing.T, name string, data []byte) *itemServer {
+	t.Helper()
+	s := &itemServer{Name: name, Data: data}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *itemServer) ItemUrl() string {
+	return s.URL + "/downloads/" + s.Name
+}
+
+func (s *itemServer) Ranges() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.ranges...)
+}
+
+func (s *itemServer) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/downlink/" + s.Name:
+		checksumUrl := ""
+		if s.Checksum != "" {
+			checksumUrl = s.URL + "/checksum/" + s.Name
+		}
+		json.NewEncoder(w).Encode(&Downlink{Downlink: s.ItemUrl(), Checksum: checksumUrl})
+	case "/checksum/" + s.Name:
+		w.Write([]byte(s.Checksum))
+	case "/downloads/" + s.Name:
+		if r.Method == http.MethodGet {
+			s.mutex.Lock()
+			s.ranges = append(s.ranges, r.Header.Get("Range"))
+			s.mutex.Unlock()
+		}
+		if s.IgnoreRange {
+			r.Header.Del("Range")
+		}
+		if s.CutAfter > 0 && r.Method == http.MethodGet {
+			w = &cutWriter{ResponseWriter: w, left: s.CutAfter}
+		}
+		http.ServeContent(w, r, s.Name, time.Time{}, bytes.NewReader(s.Data))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Aborts the response mid-body like a dropped connection.
+type cutWriter struct {
+	http.ResponseWriter
+	left int64
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.left {
+		w.ResponseWriter.Write(p[:w.left])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.left -= int64(len(p))
+	return w.ResponseWriter.Write(p)
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// GOG's checksum XML, with chunk hashes every chunkSize bytes if it's set.
+func checksumXml(data []byte, chunkSize int) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `<file name="item" md5="%s" total_size="%d">`,
+		md5Hex(data), len(data))
+	for from := 0; chunkSize > 0 && from < len(data); from += chunkSize {
+		to := from + chunkSize
+		if to > len(data) {
+			to = len(data)
+		}
+		fmt.Fprintf(&builder, `<chunk from="%d" to="%d" method="md5">%s</chunk>`,
+			from, to-1, md5Hex(data[from:to]))
+	}
+	builder.WriteString("</file>")
+	return builder.String()
+}
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestVerifyItemNoChunkHashes(t *testing.T) {
+	data := testData(1000)
+	srv := newItemServer(t, "setup.exe", data)
+	// Right size, wrong hash and nothing to narrow it down with.
+	srv.Checksum = fmt.Sprintf(`<file name="setup.exe" md5="%s" total_size="%d"></file>`,
+		strings.Repeat("0", 32), len(data))
+	fpath := filepath.Join(t.TempDir(), "setup.exe")
+	err := os.WriteFile(fpath, data, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifyItem(srv.ItemUrl(), "setup.exe", fpath)
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Fatalf("verifyItem() = %v, want a checksum mismatch", err)
+	}
+	if len(srv.Ranges()) != 0 {
+		t.Errorf("verifyItem() redownloaded %v with no chunk hashes to go on", srv.Ranges())
+	}
+}
+
+func TestFetchItemMismatchStartsOver(t *testing.T) {
+	for _, connections := range []int{1, 4} {
+		data := testData(1000)
+		srv := newItemServer(t, "setup.exe", data)
+		// Chunk hashes of other data, so the repair can't fix it either.
+		srv.Checksum = checksumXml(testData(1001)[1:], 250)
+		cfg := &Config{Connections: connections}
+		outPath := t.TempDir()
+		download := &Download{ManualURL: srv.ItemUrl(), Name: "setup"}
+
+		for run := 1; run <= 2; run++ {
+			_, _, err := fetchItem(cfg, download, outPath, false)
+			if !errors.Is(err, errChecksumMismatch) {
+				t.Fatalf("connections %d, run %d: got %v, want a checksum mismatch",
+					connections, run, err)
+			}
+			entries, err := os.ReadDir(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("connections %d, run %d: %s left behind",
+					connections, run, entries[0].Name())
+			}
+		}
+		for _, r := range srv.Ranges() {
+			if r == "bytes=1000-" {
+				t.Errorf("connections %d: asked for the bytes after a full size partial", connections)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const stateSaveInterval = 2 * time.Second
+
+var errRangeIgnored = errors.New("server ignored range request")
+
+func (cw *ChunkWriter) Write(p []byte) (int, error) {
+	n, err := cw.File.WriteAt(p, cw.Chunk.Start+cw.Chunk.Done)
+	cw.Mutex.Lock()
+	cw.Chunk.Done += int64(n)
+	cw.Counter.Write(p[:n])
+	cw.Mutex.Unlock()
+	return n, err
+}
+
+func splitChunks(size int64, connections int) []*Chunk {
+	var chunks []*Chunk
+	count := int64(connections)
+	if size < count {
+		count = size
+	}
+	chunkSize := size / count
+	for i := int64(0); i < count; i++ {
+		start := i * chunkSize
+		end := start + chunkSize - 1
+		// Fold the remainder into the last chunk.
+		if i == count-1 {
+			end = size - 1
+		}
+		chunks = append(chunks, &Chunk{Start: start, End: end})
+	}
+	return chunks
+}
+
+func readChunkState(statePath string) (*ChunkState, error) {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, err
+	}
+	var obj ChunkState
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// Returns the saved state if the chunks can be picked up again, nil if the
+// partial download has to start over.
+func getResumeState(statePath string, incompExists bool, size int64) (*ChunkState, error) {
+	if !incompExists || size <= 0 {
+		return nil, nil
+	}
+	state, err := readChunkState(statePath)
+	if err != nil {
+		// Only a failed read is an error, a mangled state file just means
+		// starting over.
+		if _, ok := err.(*os.PathError); ok {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if state.Size != size || len(state.Chunks) == 0 {
+		return nil, nil
+	}
+	return state, nil
+}
+
+func removePartial(incompPath, statePath string) error {
+	for _, fpath := range []string{incompPath, statePath} {
+		err := os.Remove(fpath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeChunkState(statePath string, state *ChunkState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmpPath := statePath + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, statePath)
+}
+
+func downloadChunk(itemUrl string, cw *ChunkWriter) error {
+	chunk := cw.Chunk
+	if chunk.Start+chunk.Done > chunk.End {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, itemUrl, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add(
+		"Range", "bytes="+strconv.FormatInt(chunk.Start+chunk.Done, 10)+
+			"-"+strconv.FormatInt(chunk.End, 10))
+
+	do, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusPartialContent {
+		if do.StatusCode == http.StatusOK {
+			return errRangeIgnored
+		}
+		return errors.New(do.Status)
+	}
+	_, err = io.Copy(cw, do.Body)
+	if err != nil {
+		return err
+	}
+	if chunk.Start+chunk.Done <= chunk.End {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// Splits the item into byte ranges fetched concurrently into a preallocated
+// .incomplete file. Chunk progress goes to a state file next to it so a
+// killed run only fetches what's missing.
+func downloadChunked(itemUrl, name, incompPath, statePath string, size int64, connections int) error {
+	state, err := readChunkState(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if state != nil && state.Size == size {
+		printLine("Incomplete item exists locally. Resuming...")
+	} else {
+		state = &ChunkState{Size: size, Chunks: splitChunks(size, connections)}
+	}
+
+	f, err := os.OpenFile(incompPath, os.O_CREATE|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = f.Truncate(size)
+	if err != nil {
+		return err
+	}
+
+	var downloaded int64
+	for _, chunk := range state.Chunks {
+		downloaded += chunk.Done
+	}
+	counter := newCounter(name, size, downloaded)
+
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	errs := make([]error, len(state.Chunks))
+	for i, chunk := range state.Chunks {
+		cw := &ChunkWriter{File: f, Chunk: chunk, Counter: counter, Mutex: &mutex}
+		wg.Add(1)
+		go func(i int, cw *ChunkWriter) {
+			defer wg.Done()
+			errs[i] = downloadChunk(itemUrl, cw)
+		}(i, cw)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var saveErr error
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-ticker.C:
+		}
+		mutex.Lock()
+		err = writeChunkState(statePath, state)
+		mutex.Unlock()
+		if err != nil && saveErr == nil {
+			saveErr = err
+		}
+	}
+	finishCounter(counter)
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if saveErr != nil {
+		return saveErr
+	}
+	return os.Remove(statePath)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetResumeState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "setup.incomplete.state")
+	err := writeChunkState(statePath, &ChunkState{Size: 1000, Chunks: splitChunks(1000, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mangledPath := statePath + ".mangled"
+	err = os.WriteFile(mangledPath, []byte("{"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		statePath    string
+		incompExists bool
+		size         int64
+		want         bool
+	}{
+		{"resumable", statePath, true, 1000, true},
+		{"no partial", statePath, false, 1000, false},
+		{"size changed", statePath, true, 1200, false},
+		{"unknown size", statePath, true, 0, false},
+		{"mangled state", mangledPath, true, 1000, false},
+	}
+	for _, tt := range tests {
+		state, err := getResumeState(tt.statePath, tt.incompExists, tt.size)
+		if err != nil {
+			t.Errorf("%s: got error %v", tt.name, err)
+			continue
+		}
+		if (state != nil) != tt.want {
+			t.Errorf("%s: got state %v, want resumable %v", tt.name, state, tt.want)
+		}
+	}
+}
+
+func TestDownloadChunkedResume(t *testing.T) {
+	data := testData(1000)
+	srv := newItemServer(t, "setup.exe", data)
+	// Every connection drops 100 bytes into its 250 byte chunk.
+	srv.CutAfter = 100
+	incompPath := filepath.Join(t.TempDir(), "setup.incomplete")
+	statePath := incompPath + ".state"
+
+	err := downloadChunked(srv.ItemUrl(), "setup.exe", incompPath, statePath, 1000, 4)
+	if err == nil {
+		t.Fatal("killed download succeeded")
+	}
+	state, err := getResumeState(statePath, true, 1000)
+	if err != nil || state == nil {
+		t.Fatalf("got state %v, %v after the kill, want one to resume from", state, err)
+	}
+	var want []string
+	for _, chunk := range state.Chunks {
+		if chunk.Done <= 0 || chunk.Start+chunk.Done > chunk.End {
+			t.Errorf("chunk %d-%d saved with %d done, want part of it", chunk.Start, chunk.End, chunk.Done)
+		}
+		want = append(want, fmt.Sprintf("bytes=%d-%d", chunk.Start+chunk.Done, chunk.End))
+	}
+
+	srv.CutAfter = 0
+	before := len(srv.Ranges())
+	err = downloadChunked(srv.ItemUrl(), "setup.exe", incompPath, statePath, 1000, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(incompPath)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("resumed file doesn't match the item, %v", err)
+	}
+	exists, _, _ := fileExists(statePath)
+	if exists {
+		t.Error("state file left behind after the download finished")
+	}
+
+	// Only what's missing gets asked for.
+	requested := map[string]bool{}
+	for _, r := range srv.Ranges()[before:] {
+		requested[r] = true
+	}
+	if len(requested) != len(want) {
+		t.Errorf("resume asked for %v, want %v", srv.Ranges()[before:], want)
+	}
+	for _, r := range want {
+		if !requested[r] {
+			t.Errorf("resume didn't ask for %s, got %v", r, srv.Ranges()[before:])
+		}
+	}
+}
+
+func TestFetchItemSizeChanged(t *testing.T) {
+	data := testData(1000)
+	srv := newItemServer(t, "setup.exe", data)
+	outPath := t.TempDir()
+	incompPath := filepath.Join(outPath, "setup.incomplete")
+	statePath := incompPath + ".state"
+	// Left over from an older build of the item.
+	err := os.WriteFile(incompPath, testData(800), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &ChunkState{Size: 800, Chunks: splitChunks(800, 4)}
+	for _, chunk := range state.Chunks {
+		chunk.Done = 100
+	}
+	err = writeChunkState(statePath, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Connections: 4}
+	download := &Download{ManualURL: srv.ItemUrl(), Name: "setup"}
+	incomp, _, err := fetchItem(cfg, download, outPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incomp != incompPath {
+		t.Fatalf("downloaded to %s, want %s", incomp, incompPath)
+	}
+	got, err := os.ReadFile(incomp)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("restarted file doesn't match the item, %v", err)
+	}
+	for _, chunk := range splitChunks(1000, 4) {
+		r := fmt.Sprintf("bytes=%d-%d", chunk.Start, chunk.End)
+		found := false
+		for _, got := range srv.Ranges() {
+			found = found || got == r
+		}
+		if !found {
+			t.Errorf("restart didn't ask for %s, got %v", r, srv.Ranges())
+		}
+	}
+}
+
+func TestFetchItemRangeIgnored(t *testing.T) {
+	tests := []struct {
+		name        string
+		connections int
+		partial     []byte
+	}{
+		{"chunked", 4, nil},
+		{"chunked resume", 4, testData(1000)},
+		{"sequential resume", 1, testData(300)},
+	}
+	for _, tt := range tests {
+		data := testData(1000)
+		srv := newItemServer(t, "setup.exe", data)
+		srv.IgnoreRange = true
+		outPath := t.TempDir()
+		incompPath := filepath.Join(outPath, "setup.incomplete")
+		if tt.partial != nil {
+			err := os.WriteFile(incompPath, tt.partial, 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.connections > 1 && tt.partial != nil {
+			err := writeChunkState(incompPath+".state",
+				&ChunkState{Size: 1000, Chunks: splitChunks(1000, tt.connections)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cfg := &Config{Connections: tt.connections}
+		download := &Download{ManualURL: srv.ItemUrl(), Name: "setup"}
+		incomp, _, err := fetchItem(cfg, download, outPath, false)
+		if err != nil || incomp != incompPath {
+			t.Errorf("%s: got %s, %v, want %s", tt.name, incomp, err, incompPath)
+			continue
+		}
+		got, err := os.ReadFile(incomp)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: got %d bytes, %v, want the item's 1000", tt.name, len(got), err)
+		}
+		_, err = os.Stat(incompPath + ".state")
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: state file left behind, %v", tt.name, err)
+		}
+	}
+}
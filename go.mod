@@ -1,4 +1,4 @@
-module main
+module github.com/Sorrow446/GOG-Downloader
 
 go 1.19
 
@@ -6,15 +6,15 @@ require (
 	github.com/AlecAivazis/survey/v2 v2.3.6
 	github.com/alexflint/go-arg v1.4.3
 	github.com/dustin/go-humanize v1.0.0
+	github.com/mattn/go-isatty v0.0.8
+	golang.org/x/sys v0.0.0-20220422013727-9388b58f7150
+	golang.org/x/text v0.3.3
 )
 
 require (
 	github.com/alexflint/go-scalar v1.1.0 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/mattn/go-colorable v0.1.2 // indirect
-	github.com/mattn/go-isatty v0.0.8 // indirect
 	github.com/mgutz/ansi v0.0.0-20170206155736-9520e82c474b // indirect
-	golang.org/x/sys v0.0.0-20220422013727-9388b58f7150 // indirect
 	golang.org/x/term v0.0.0-20210503060354-a79de5458b56 // indirect
-	golang.org/x/text v0.3.3 // indirect
 )
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+// Set once cookies are in use, exit and fatal errors write the jar back to it.
+var sessionPath string
+
+func newPersistentJar() *PersistentJar {
+	innerJar, _ := cookiejar.New(nil)
+	return &PersistentJar{Jar: innerJar, Stored: map[string]*Cookie{}}
+}
+
+// Keeps what the std jar throws away (domain, expiry etc.) so the cookies
+// can be written back out.
+func (j *PersistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	j.Mutex.Lock()
+	defer j.Mutex.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		domain := c.Domain
+		hostOnly := domain == ""
+		if hostOnly {
+			domain = u.Hostname()
+		}
+		cPath := c.Path
+		if cPath == "" {
+			cPath = "/"
+		}
+		key := domain + ";" + cPath + ";" + c.Name
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(now)) {
+			delete(j.Stored, key)
+			continue
+		}
+
+		var expiry float64
+		if c.MaxAge > 0 {
+			expiry = float64(now.Unix() + int64(c.MaxAge))
+		} else if !c.Expires.IsZero() {
+			expiry = float64(c.Expires.Unix())
+		}
+		j.Stored[key] = &Cookie{
+			Domain:         domain,
+			ExpirationDate: expiry,
+			HostOnly:       hostOnly,
+			HTTPOnly:       c.HttpOnly,
+			Name:           c.Name,
+			Path:           cPath,
+			Secure:         c.Secure,
+			Session:        expiry == 0,
+			Value:          c.Value,
+		}
+	}
+}
+
+func (j *PersistentJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.Jar.Cookies(u)
+}
+
+// Written in the EditThisCookie format so readCookies takes it as is.
+func (j *PersistentJar) save(path string) error {
+	var cookies []*Cookie
+	j.Mutex.Lock()
+	now := float64(time.Now().Unix())
+	for _, c := range j.Stored {
+		if c.ExpirationDate != 0 && c.ExpirationDate < now {
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+	j.Mutex.Unlock()
+	sort.Slice(cookies, func(a, b int) bool {
+		return cookies[a].Domain+cookies[a].Name < cookies[b].Domain+cookies[b].Name
+	})
+
+	data, err := json.MarshalIndent(cookies, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func saveSession() {
+	if sessionPath == "" {
+		return
+	}
+	err := jar.save(sessionPath)
+	if err != nil {
+		handleErr("failed to save session cookies", err, false)
+	}
+}
+
+func exit(code int) {
+	saveSession()
+	os.Exit(code)
+}
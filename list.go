@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var listFormats = []string{"table", "json", "csv"}
+
+var listHeader = []string{
+	"id", "title", "slug", "category", "platforms", "dlcCount", "releaseDate",
+	"rating", "updated",
+}
+
+func isListFormat(format string) bool {
+	for _, f := range listFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func getWorksOn(p *Product) []string {
+	platforms := []string{}
+	if p.WorksOn.Windows {
+		platforms = append(platforms, "windows")
+	}
+	if p.WorksOn.Linux {
+		platforms = append(platforms, "linux")
+	}
+	if p.WorksOn.Mac {
+		platforms = append(platforms, "mac")
+	}
+	return platforms
+}
+
+func getListEntries(products []Product) []*ListEntry {
+	entries := []*ListEntry{}
+	for i := range products {
+		p := &products[i]
+		// 2016-02-26 00:00:00.000000
+		releaseDate := p.ReleaseDate.Date
+		if len(releaseDate) > 10 {
+			releaseDate = releaseDate[:10]
+		}
+		entries = append(entries, &ListEntry{
+			ID:          p.ID,
+			Title:       p.Title,
+			Slug:        p.Slug,
+			Category:    p.Category,
+			Platforms:   getWorksOn(p),
+			DlcCount:    p.DlcCount,
+			ReleaseDate: releaseDate,
+			Rating:      p.Rating,
+			Updated:     isUpdated(*p),
+		})
+	}
+	return entries
+}
+
+func (e *ListEntry) row() []string {
+	return []string{
+		strconv.Itoa(e.ID), e.Title, e.Slug, e.Category,
+		strings.Join(e.Platforms, ","), strconv.Itoa(e.DlcCount), e.ReleaseDate,
+		strconv.Itoa(e.Rating), strconv.FormatBool(e.Updated),
+	}
+}
+
+func writeTable(w io.Writer, entries []*ListEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var header []string
+	for _, name := range listHeader {
+		header = append(header, strings.ToUpper(name))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, e := range entries {
+		fmt.Fprintln(tw, strings.Join(e.row(), "\t"))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n%d product(s).\n", len(entries))
+	return err
+}
+
+func writeCsv(w io.Writer, entries []*ListEntry) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(listHeader)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		err = cw.Write(e.row())
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJson(w io.Writer, entries []*ListEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(entries)
+}
+
+func writeList(w io.Writer, products []Product, format string) error {
+	entries := getListEntries(products)
+	switch format {
+	case "json":
+		return writeJson(w, entries)
+	case "csv":
+		return writeCsv(w, entries)
+	default:
+		return writeTable(w, entries)
+	}
+}
@@ -5,19 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html"
-	"html/template"
 	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
-	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 	
 	"github.com/alexflint/go-arg"
@@ -27,16 +27,28 @@ import (
 )
 
 const (
+	appName = "gog-downloader"
 	defTemplate = "{{.title}} [GOG]"
-	sanRegexStr = `[\/:*?"><|]`
-	siteUrl = "https://www.gog.com"
+	selectAllOpt = "[Select all]"
+	defCookiesPath = "cookies.json"
+	defTokenPath = "token.json"
+	defSessionPath = "session.json"
+	// Used when neither the config file nor env or args set them.
+	defPlatform = "windows"
+	defLanguage = "en"
+	expiryWarning = 3 * 24 * time.Hour
+	netscapeHttpOnly = "#HttpOnly_"
 	userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/"+
 		"537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Safari/537.36"
 )
 
 var (
-	jar, _ = cookiejar.New(nil)
+	// Switched to the embed host when signed in with a token.
+	siteUrl = "https://www.gog.com"
+	jar = newPersistentJar()
 	client = &http.Client{Transport: &Transport{}, Jar: jar}
+	// Status messages, stderr for list so stdout only has the listing.
+	statusOut io.Writer = os.Stdout
 )
 
 var resolvePlatform = map[string]string{
@@ -45,6 +57,21 @@ var resolvePlatform = map[string]string{
 	"mac": "16,32",
 }
 
+// What "all" expands to, in this order.
+var platformNames = []string{"windows", "linux", "mac"}
+
+// The ones GOG's sign in depends on.
+var authCookieNames = []string{"gog-al", "gog_us"}
+
+var commands = []string{
+	"verify",
+	"update",
+	"login",
+	"config",
+	"template",
+	"list",
+}
+
 var languages = []string{
 	"en", "cz", "de", "es", "fr", "it",
 	"hu", "nl", "pl", "pt", "br", "sv",
@@ -52,17 +79,69 @@ var languages = []string{
 	"jp", "all",
 }
 
+// Labels GOG uses for the language groups in game details.
+var langNames = map[string][]string{
+	"en": {"English"},
+	"cz": {"český", "Czech"},
+	"de": {"Deutsch", "German"},
+	"es": {"español", "Spanish"},
+	"fr": {"français", "French"},
+	"it": {"italiano", "Italian"},
+	"hu": {"magyar", "Hungarian"},
+	"nl": {"nederlands", "Dutch"},
+	"pl": {"polski", "Polish"},
+	"pt": {"português", "Portuguese"},
+	"br": {"Português do Brasil", "Brazilian Portuguese"},
+	"sv": {"svenska", "Swedish"},
+	"tr": {"Türkçe", "Turkish"},
+	"uk": {"українська", "Ukrainian"},
+	"ru": {"русский", "Russian"},
+	"ar": {"العربية", "Arabic"},
+	"ko": {"한국어", "Korean"},
+	"cn": {"中文(简体)", "中文", "Chinese"},
+	"jp": {"日本語", "Japanese"},
+}
+
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.Header.Add("User-Agent", userAgent)
 	req.Header.Add("Referer", siteUrl+"/")
 	req.Header.Add("Origin", siteUrl)
+	if strings.HasSuffix(req.URL.Hostname(), ".gog.com") {
+		token, err := getAccessToken()
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
 	return http.DefaultTransport.RoundTrip(req)
 }
 
+func (l *LangDownloads) UnmarshalJSON(data []byte) error {
+	var pair []json.RawMessage
+	err := json.Unmarshal(data, &pair)
+	if err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("expected language/downloads pair, got %d elements", len(pair))
+	}
+	err = json.Unmarshal(pair[0], &l.Language)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(pair[1], &l.Platforms)
+}
+
 
 func (wc *WriteCounter) Write(p []byte) (int, error) {
 	var speed int64 = 0
 	n := len(p)
+	if wc.Progress != nil {
+		wc.Progress.update(wc, n)
+		return n, nil
+	}
 	wc.Downloaded += int64(n)
 	percentage := float64(wc.Downloaded) / float64(wc.Total) * float64(100)
 	wc.Percentage = int(percentage)
@@ -78,9 +157,11 @@ func (wc *WriteCounter) Write(p []byte) (int, error) {
 func handleErr(errText string, err error, _panic bool) {
 	errString := errText + "\n" + err.Error()
 	if _panic {
+		// Nothing gets to call exit after this.
+		saveSession()
 		panic(errString)
 	}
-	fmt.Println(errString)
+	printLine(errString)
 }
 
 func wasRunFromSrc() bool {
@@ -109,8 +190,53 @@ func getScriptDir() (string, error) {
 	return filepath.Dir(fname), nil
 }
 
-func readConfig() (*Config, error) {
-	data, err := os.ReadFile("config.json")
+// --config, then the XDG config folder, then next to the binary where it
+// used to have to be.
+func findConfig(cfgPath string) (string, error) {
+	var candidates []string
+	if cfgPath != "" {
+		return cfgPath, nil
+	}
+	xdgDir := os.Getenv("XDG_CONFIG_HOME")
+	if xdgDir != "" {
+		candidates = append(candidates, filepath.Join(xdgDir, appName, "config.json"))
+	}
+	homeDir, err := os.UserHomeDir()
+	if err == nil {
+		candidates = append(
+			candidates, filepath.Join(homeDir, ".config", appName, "config.json"))
+	}
+	scriptDir, err := getScriptDir()
+	if err == nil {
+		candidates = append(candidates, filepath.Join(scriptDir, "config.json"))
+	}
+
+	for _, candidate := range candidates {
+		exists, _, err := fileExists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return candidate, nil
+		}
+	}
+	// Not an error, everything can come from env vars and args.
+	return "", nil
+}
+
+func resolvePath(dir, fpath string) string {
+	if fpath == "" || filepath.IsAbs(fpath) {
+		return fpath
+	}
+	return filepath.Join(dir, fpath)
+}
+
+func readConfig(cfgPath string) (*Config, error) {
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	err = validateConfig(cfgPath, data)
 	if err != nil {
 		return nil, err
 	}
@@ -122,12 +248,42 @@ func readConfig() (*Config, error) {
 	return &obj, nil
 }
 
+func isCommand(name string) bool {
+	for _, command := range commands {
+		if command == name {
+			return true
+		}
+	}
+	return false
+}
+
+// go-arg can't mix a positional with subcommands, so commands get their own
+// parser and the bare query keeps working as before.
 func parseArgs() *Args {
 	var args Args
+	if len(os.Args) > 1 && isCommand(os.Args[1]) {
+		var cmdArgs CmdArgs
+		arg.MustParse(&cmdArgs)
+		args.Options = cmdArgs.Options
+		args.Cmd = &cmdArgs
+		if cmdArgs.Verify != nil {
+			args.Query = cmdArgs.Verify.Query
+		} else if cmdArgs.Update != nil {
+			args.Query = cmdArgs.Update.Query
+		} else if cmdArgs.List != nil {
+			args.Query = cmdArgs.List.Query
+		}
+		return &args
+	}
 	arg.MustParse(&args)
 	return &args
 }
 
+func (Args) Description() string {
+	return "Commands: " + strings.Join(commands, ", ") +
+		". Run with COMMAND --help for their options."
+}
+
 func makeDirs(path string) error {
 	err := os.MkdirAll(path, 0755)
 	return err
@@ -142,73 +298,270 @@ func checkLang(userLang string) bool {
 	return false
 }
 
+func normalisePlatform(platform string) string {
+	platform = strings.ToLower(strings.TrimSpace(platform))
+	if platform == "win" {
+		platform = "windows"
+	} else if platform == "osx" {
+		platform = "mac"
+	}
+	return platform
+}
+
+// Returns the platforms and their merged search IDs.
+func parsePlatforms(platformsStr string) ([]string, string, error) {
+	var (
+		platforms []string
+		ids []string
+	)
+	seen := map[string]bool{}
+	for _, platform := range strings.Split(platformsStr, ",") {
+		platform = normalisePlatform(platform)
+		if platform == "" || seen[platform] {
+			continue
+		}
+		if platform == "all" {
+			return parsePlatforms(strings.Join(platformNames, ","))
+		}
+		platformIds, ok := resolvePlatform[platform]
+		if !ok {
+			return nil, "", errors.New("invalid platform: " + platform)
+		}
+		seen[platform] = true
+		platforms = append(platforms, platform)
+		ids = append(ids, platformIds)
+	}
+	if len(platforms) == 0 {
+		return nil, "", errors.New("platform is required")
+	}
+	return platforms, strings.Join(ids, ","), nil
+}
+
+func parseLangs(langsStr string) ([]string, error) {
+	var langs []string
+	for _, lang := range strings.Split(langsStr, ",") {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "" {
+			continue
+		}
+		if !checkLang(lang) {
+			return nil, errors.New("invalid language: " + lang)
+		}
+		if lang == "all" {
+			return []string{"all"}, nil
+		}
+		langs = append(langs, lang)
+	}
+	if len(langs) == 0 {
+		return nil, errors.New("language is required")
+	}
+	return langs, nil
+}
+
+// Precedence is args > env > profile > config file > defaults.
 func parseCfg() (*Config, error) {
-	cfg, err := readConfig()
+	args := parseArgs()
+	if args.ConfigPath == "" {
+		args.ConfigPath = getEnv("CONFIG")
+	}
+	if args.Profile == "" {
+		args.Profile = getEnv("PROFILE")
+	}
+	cfgPath, err := findConfig(args.ConfigPath)
 	if err != nil {
 		return nil, err
 	}
+	// Reports problems itself rather than failing here.
+	if args.Cmd != nil && args.Cmd.Config != nil {
+		return &Config{Command: "config check", ConfigPath: cfgPath}, nil
+	}
+	var cfg *Config
+	if cfgPath == "" {
+		cfg = &Config{}
+	} else {
+		cfg, err = readConfig(cfgPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg.ConfigPath = cfgPath
+
+	if args.Profile != "" {
+		err = applyProfile(cfg, args.Profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Files named in the config sit next to it, ones from env and args are
+	// relative to the working dir like outPath.
+	if cfg.CookiesPath == "" {
+		cfg.CookiesPath = defCookiesPath
+	}
+	if cfg.SessionPath == "" {
+		cfg.SessionPath = defSessionPath
+	}
+	if cfg.TokenPath == "" {
+		cfg.TokenPath = defTokenPath
+	}
+	// Working dir when there's no config file.
+	var cfgDir string
+	if cfgPath != "" {
+		cfgDir = filepath.Dir(cfgPath)
+	}
+	cfg.CookiesPath = resolvePath(cfgDir, cfg.CookiesPath)
+	cfg.SessionPath = resolvePath(cfgDir, cfg.SessionPath)
+	cfg.TokenPath = resolvePath(cfgDir, cfg.TokenPath)
+
+	envSettings, err := getEnvSettings()
+	if err != nil {
+		return nil, err
+	}
+	applySettings(cfg, envSettings)
+	applySettings(cfg, getArgSettings(args))
 
-	args := parseArgs()
 	query := strings.TrimSpace(args.Query)
-	if query != "" && len(query) < 3 {
+	if query != "" && len(query) < 2 {
 		return nil, errors.New("query must be at least two characters")
 	}
 
 	cfg.Query = args.Query
-	if args.Platform != "" {
-		cfg.Platform = args.Platform
-	}
-	if args.Language != "" {
-		cfg.Language = args.Language
+	cfg.DryRun = args.DryRun
+	if args.Cmd != nil && args.Cmd.Verify != nil {
+		cfg.Command = "verify"
+		cfg.Repair = args.Cmd.Verify.Repair && !cfg.DryRun
+	} else if args.Cmd != nil && args.Cmd.Update != nil {
+		cfg.Command = "update"
+		cfg.Archive = args.Cmd.Update.Archive
+	} else if args.Cmd != nil && args.Cmd.Login != nil {
+		cfg.Command = "login"
+		cfg.AuthCode = args.Cmd.Login.Code
+	} else if args.Cmd != nil && args.Cmd.Template != nil {
+		cfg.Command = "template preview"
+	} else if args.Cmd != nil && args.Cmd.List != nil {
+		cfg.Command = "list"
+		cfg.ListFormat = strings.ToLower(args.Cmd.List.Format)
+		if cfg.ListFormat == "" {
+			cfg.ListFormat = "table"
+		}
+		if !isListFormat(cfg.ListFormat) {
+			return nil, errors.New("invalid list format: " + cfg.ListFormat +
+				", expected " + strings.Join(listFormats, ", "))
+		}
+		// The whole library unless -p or -l narrow it down, the config's
+		// platform and language are for downloads.
+		if args.Platform == "" && envSettings.Platform == "" {
+			cfg.Platform = "all"
+		}
+		if args.Language == "" && envSettings.Language == "" {
+			cfg.Language = "all"
+		}
 	}
-	if args.FolderTemplate != "" {
-		cfg.FolderTemplate = args.FolderTemplate
+
+	if cfg.Connections < 1 {
+		cfg.Connections = 1
 	}
-	if args.Goodies {
-		cfg.Goodies = args.Goodies 
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
 	}
 
-	if strings.TrimSpace(cfg.Platform) == "" || strings.TrimSpace(cfg.Language) == "" {
-		return nil, errors.New("platform and language are required")
+	if strings.TrimSpace(cfg.Platform) == "" {
+		cfg.Platform = defPlatform
 	}
-
-	lang := strings.ToLower(cfg.Language)
-	if !checkLang(lang) {
-		return nil, errors.New("invalid language: " + cfg.Language)
+	if strings.TrimSpace(cfg.Language) == "" {
+		cfg.Language = defLanguage
 	}
-	args.Language = lang
 
-	platform := strings.ToLower(cfg.Platform)
-	if platform == "win" {
-		platform = "windows"
-	} else if platform == "osx" {
-		platform = "mac"
+	langs, err := parseLangs(cfg.Language)
+	if err != nil {
+		return nil, err
 	}
-	platformIds, ok := resolvePlatform[platform]
-	if !ok {
-		return nil, errors.New("invalid platform: " + cfg.Platform)
+	cfg.Languages = langs
+
+	platforms, platformIds, err := parsePlatforms(cfg.Platform)
+	if err != nil {
+		return nil, err
 	}
-	cfg.Platform = platform
+	cfg.Platforms = platforms
+	cfg.Platform = strings.Join(platforms, ",")
 	cfg.PlatformIDs = platformIds
-	if args.OutPath != "" {
-		cfg.OutPath = args.OutPath
-	}
 	if cfg.OutPath == "" {
 		cfg.OutPath = "GOG downloads"
 	}
+	if cfg.Sanitise == "" {
+		cfg.Sanitise = "windows-safe"
+	}
+	if !isSanitisePolicy(cfg.Sanitise) {
+		return nil, errors.New("invalid sanitise policy: " + cfg.Sanitise +
+			", expected " + strings.Join(sanitisePolicies, ", "))
+	}
+	sanitisePolicy = cfg.Sanitise
 	if strings.TrimSpace(cfg.FolderTemplate) == "" {
 		cfg.FolderTemplate = defTemplate
 	}
+	cfg.FolderTmpl, err = compileTemplate("folderTemplate", cfg.FolderTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(cfg.FileTemplate) != "" {
+		cfg.FileTmpl, err = compileTemplate("fileTemplate", cfg.FileTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cfg.SplitPlatforms = splitsPlatforms(cfg.FolderTmpl)
 
 	return cfg, nil
 }
 
-func readCookies() ([]*Cookie, error) {
-	data, err := os.ReadFile("cookies.json")
+// Netscape/Mozilla cookies.txt. #HttpOnly_ prefixed lines are cookies too.
+// Expired ones are kept so checkCookieExpiry can report them, the jar drops
+// them anyway.
+func parseNetscapeCookies(data []byte) ([]*Cookie, error) {
+	var cookies []*Cookie
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		httpOnly := strings.HasPrefix(line, netscapeHttpOnly)
+		if httpOnly {
+			line = line[len(netscapeHttpOnly):]
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", i+1, len(fields))
+		}
+		expiry, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad expiry: %s", i+1, fields[4])
+		}
+		cookie := &Cookie{
+			Domain:         fields[0],
+			HostOnly:       strings.ToUpper(fields[1]) != "TRUE",
+			Path:           fields[2],
+			Secure:         strings.ToUpper(fields[3]) == "TRUE",
+			ExpirationDate: expiry,
+			Session:        expiry == 0,
+			HTTPOnly:       httpOnly,
+			Name:           fields[5],
+			Value:          fields[6],
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, nil
+}
+
+func readCookies(cookiesPath string) ([]*Cookie, error) {
+	data, err := os.ReadFile(cookiesPath)
 	if err != nil {
 		return nil, err
 	}
 
+	// EditThisCookie dumps a JSON array, anything else is taken as Netscape.
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return parseNetscapeCookies(data)
+	}
 	var obj []*Cookie
 	err = json.Unmarshal(data, &obj)
 	if err != nil {
@@ -217,17 +570,39 @@ func readCookies() ([]*Cookie, error) {
 	return obj, nil
 }
 
+// The session store's only used if it was written after the cookies file,
+// otherwise a fresh export would get overridden by stale cookies.
+func isSessionNewer(cookiesPath, sessionPath string) (bool, error) {
+	sessionStat, err := os.Stat(sessionPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	cookiesStat, err := os.Stat(cookiesPath)
+	if err != nil {
+		return false, err
+	}
+	return sessionStat.ModTime().After(cookiesStat.ModTime()), nil
+}
+
 func setCookies(_cookies []*Cookie) error {
 	var cookies []*http.Cookie
 	for _, _cookie := range _cookies {
 		name := _cookie.Name
 		value := _cookie.Value
 		cookie := &http.Cookie{
-			Domain: _cookie.Domain,
-			Name:   name,
-			Path:   _cookie.Path,
-			Secure: _cookie.Secure,
-			Value:  value,
+			Domain:   _cookie.Domain,
+			Name:     name,
+			Path:     _cookie.Path,
+			Secure:   _cookie.Secure,
+			HttpOnly: _cookie.HTTPOnly,
+			Value:    value,
+		}
+		// Session cookies have no expiry even if the export gave them one.
+		if !_cookie.Session && _cookie.ExpirationDate != 0 {
+			cookie.Expires = time.Unix(int64(_cookie.ExpirationDate), 0)
 		}
 		cookies = append(cookies, cookie)
 	}
@@ -241,28 +616,67 @@ func setCookies(_cookies []*Cookie) error {
 	return nil
 }
 
-func checkCookies() (bool, error) {
+// Splits the auth cookies into expired ones and ones expiring within
+// expiryWarning. Later cookies win, so session store ones go last.
+func checkCookieExpiry(cookies []*Cookie) ([]string, []string) {
+	var expired, expiring []string
+	latest := map[string]*Cookie{}
+	for _, c := range cookies {
+		latest[c.Name] = c
+	}
+	now := time.Now()
+	for _, name := range authCookieNames {
+		c, ok := latest[name]
+		if !ok || c.Session || c.ExpirationDate == 0 {
+			continue
+		}
+		expiry := time.Unix(int64(c.ExpirationDate), 0)
+		if expiry.Before(now) {
+			expired = append(expired, name)
+		} else if expiry.Before(now.Add(expiryWarning)) {
+			expiring = append(expiring, name+" ("+expiry.Format("2006-01-02 15:04")+")")
+		}
+	}
+	return expired, expiring
+}
+
+func checkCookies(cookies []*Cookie) (*UserData, error) {
+	expired, expiring := checkCookieExpiry(cookies)
 	req, err := client.Get(siteUrl+"/userData.json")
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer req.Body.Close()
 	if req.StatusCode != http.StatusOK {
-		return false, errors.New(req.Status)
+		return nil, errors.New(req.Status)
 	}
 	var obj UserData
     err = json.NewDecoder(req.Body).Decode(&obj)
     if err != nil {
-    	return false, err
+    	return nil, err
+    }
+    if !obj.IsLoggedIn {
+    	if len(expired) > 0 {
+    		return nil, errors.New(
+    			"cookies expired, re-export: " + strings.Join(expired, ", "))
+    	}
+    	return nil, errors.New("not signed in, bad cookies")
     }
-    ok := obj.IsLoggedIn
-    if ok {
-    	fmt.Println("Signed in as " + obj.Username + ".\n")
+    fmt.Fprintln(statusOut, "Signed in as "+obj.Username+".\n")
+    if len(expiring) > 0 {
+    	fmt.Fprintln(statusOut, "Cookies expiring soon, re-export before then: "+
+    		strings.Join(expiring, ", ") + "\n")
     }
-    return ok, nil
+    return &obj, nil
 }
 
-func search(queryStr, platformIds, lang string) ([]Product, error) {
+// GOG flags products with new builds since the user last looked at them.
+// IsNew is set for fresh purchases, which aren't updates.
+func isUpdated(p Product) bool {
+	return p.Updates > 0
+}
+
+func search(queryStr, platformIds string, langs []string, updatedOnly bool) ([]Product, error) {
 	req, err := http.NewRequest(
 		http.MethodGet, siteUrl+"/account/getFilteredProducts", nil)
 	if err != nil {
@@ -272,8 +686,9 @@ func search(queryStr, platformIds, lang string) ([]Product, error) {
 	pageNum := 1
 	query := url.Values{}
 	query.Set("hiddenFlag", "0")
-	if lang != "all" {
-		query.Set("language", lang)
+	// The filter only takes one language, the rest get filtered per item.
+	if len(langs) == 1 && langs[0] != "all" {
+		query.Set("language", langs[0])
 	}
 	query.Set("mediaType", "1")
 	query.Set("sortBy", "date_purchased")
@@ -282,6 +697,9 @@ func search(queryStr, platformIds, lang string) ([]Product, error) {
 	}
 	query.Set("system", platformIds)
 	query.Set("totalPages", "1")
+	if updatedOnly {
+		query.Set("isUpdated", "1")
+	}
 	var products []Product
 
 	for {
@@ -308,7 +726,11 @@ func search(queryStr, platformIds, lang string) ([]Product, error) {
 			break
 		}
 
-		products = append(products, obj.Products...)
+		for _, p := range obj.Products {
+			if !updatedOnly || isUpdated(p) {
+				products = append(products, p)
+			}
+		}
 		if pageNum == obj.TotalPages {
 			break
 		}
@@ -319,37 +741,52 @@ func search(queryStr, platformIds, lang string) ([]Product, error) {
 	return products, nil
 }
 
-func getUserGameIdx(products []Product, prodLen int) (int, error) {
+func getUserGameIndexes(products []Product) ([]int, error) {
 	var (
-		idx int
+		indexes []int
 		opts []string
 	)
+	opts = append(opts, selectAllOpt)
 	for _, p := range products {
 		opts = append(opts, p.Title)
 	}
-	prompt := &survey.Select{Options: opts}
+	prompt := &survey.MultiSelect{Options: opts}
 	err := survey.AskOne(
-		prompt, &idx, survey.WithValidator(survey.Required),
+		prompt, &indexes, survey.WithValidator(survey.Required),
 		survey.WithPageSize(10))
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if indexes[0] == 0 {
+		indexes = nil
+		for idx := range products {
+			indexes = append(indexes, idx)
+		}
+		return indexes, nil
+	}
+	// Shift past the select all option.
+	for i := range indexes {
+		indexes[i]--
 	}
-	return idx, nil
+	return indexes, nil
 }
 
-func selectGameId(products []Product, queryStr string) (int, error) {
-	prodLen := len(products)
-	if prodLen == 1 {
-		return products[0].ID, nil
+func selectProducts(products []Product, queryStr string) ([]Product, error) {
+	var selected []Product
+	if len(products) == 1 {
+		return products, nil
 	}
 	if queryStr != "" {
 		fmt.Println("Your search yielded more than one result.")
 	}
-	idx, err := getUserGameIdx(products, prodLen)
+	indexes, err := getUserGameIndexes(products)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	for _, idx := range indexes {
+		selected = append(selected, products[idx])
 	}
-	return products[idx].ID, nil
+	return selected, nil
 }
 
 func getGameMeta(id int) (*GameMeta, error) {
@@ -371,36 +808,143 @@ func getGameMeta(id int) (*GameMeta, error) {
     return &obj, nil
 }
 
-func parseDownloads(meta *GameMeta, platform string, goodies bool) ([]*Download, error) {
+func wantLang(label string, langs []string) bool {
+	for _, lang := range langs {
+		if lang == "all" {
+			return true
+		}
+		for _, name := range langNames[lang] {
+			if strings.EqualFold(name, label) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseInstallers(meta *GameMeta, platforms []string, langs []string, dlc string) ([]*Download, error) {
 	var parsedDloads []*Download
-	// Shambles, get structs working.
-	downloads := meta.Downloads[0][1].(map[string]interface{})[platform].([]interface{})
-	for _, _d := range downloads {
-		d := _d.(map[string]interface{})
-		ver := "<no ver>"
-		if d["version"] != nil {
-			ver = d["version"].(string)
+	for _, platform := range platforms {
+		for _, group := range meta.Downloads {
+			if !wantLang(group.Language, langs) {
+				continue
+			}
+			for _, d := range group.Platforms[platform] {
+				if d.ManualURL == "" {
+					return nil, errors.New("item has no manual url: " + d.Name)
+				}
+				if d.Version == "" {
+					d.Version = "<no ver>"
+				}
+				d.ManualURL = siteUrl + d.ManualURL
+				d.Language = group.Language
+				d.Platform = platform
+				d.DLC = dlc
+				parsedDloads = append(parsedDloads, d)
+			}
 		}
-		parsedDload := &Download{
-			ManualURL: siteUrl + d["manualUrl"].(string),
-			Name: 	   d["name"].(string), 
-			Version:   ver,
-			Date:      d["date"].(string),
-			Size:      d["size"].(string),
+	}
+	return parsedDloads, nil
+}
+
+func parseExtras(meta *GameMeta, dlc string) ([]*Download, error) {
+	var parsedDloads []*Download
+	for _, e := range meta.Extras {
+		if e.ManualURL == "" {
+			return nil, errors.New("goodie has no manual url: " + e.Name)
 		}
-		parsedDloads = append(parsedDloads, parsedDload)
+		e.ManualURL = siteUrl + e.ManualURL
+		e.DLC = dlc
+		parsedDloads = append(parsedDloads, e)
+	}
+	return parsedDloads, nil
+}
+
+func parseDownloads(meta *GameMeta, platforms []string, langs []string, goodies, dlc bool) ([]*Download, error) {
+	if len(meta.Downloads) == 0 {
+		return nil, errors.New("game has no downloads")
+	}
+	parsedDloads, err := parseInstallers(meta, platforms, langs, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(parsedDloads) == 0 {
+		return nil, errors.New("no " + strings.Join(platforms, "/") +
+			" items for language(s): " + strings.Join(langs, ", "))
 	}
 
 	if goodies {
-		for _, e := range meta.Extras {
-			e.ManualURL = siteUrl + e.ManualURL
-			parsedDloads = append(parsedDloads, e)
-		}		
+		extras, err := parseExtras(meta, "")
+		if err != nil {
+			return nil, err
+		}
+		parsedDloads = append(parsedDloads, extras...)
+	}
+
+	if !dlc {
+		return parsedDloads, nil
+	}
+	// DLCs without items for the platforms or languages are just left out.
+	for _, d := range meta.Dlcs {
+		installers, err := parseInstallers(d, platforms, langs, d.Title)
+		if err != nil {
+			return nil, err
+		}
+		parsedDloads = append(parsedDloads, installers...)
+		if goodies {
+			extras, err := parseExtras(d, d.Title)
+			if err != nil {
+				return nil, err
+			}
+			parsedDloads = append(parsedDloads, extras...)
+		}
 	}
 
 	return parsedDloads, nil
 }
 
+// Only split into language folders when more than one language made it in.
+func isMultiLang(downloads []*Download) bool {
+	var first string
+	for _, d := range downloads {
+		if d.Language == "" {
+			continue
+		}
+		if first == "" {
+			first = d.Language
+		} else if d.Language != first {
+			return true
+		}
+	}
+	return false
+}
+
+func isMultiPlatform(downloads []*Download) bool {
+	var first string
+	for _, d := range downloads {
+		if d.Platform == "" {
+			continue
+		}
+		if first == "" {
+			first = d.Platform
+		} else if d.Platform != first {
+			return true
+		}
+	}
+	return false
+}
+
+// Goodies aren't tied to a platform, so they go with the first one that has
+// items.
+func getMainPlatform(downloads []*Download) string {
+	for _, d := range downloads {
+		if d.Platform != "" {
+			return d.Platform
+		}
+	}
+	return ""
+}
+
 func getLongestNameLen(downloads []*Download) int {
 	var longest int
 	for _, d := range downloads {
@@ -412,39 +956,81 @@ func getLongestNameLen(downloads []*Download) int {
 	return longest
 }
 
-func getUserDloadIndexes(downloads []*Download) ([]int, error) {
+func getUserDloadIndexes(downloads []*Download, selectDlc bool) ([]int, error) {
 	var (
-		indexes []int
+		optIndexes []int
 		opts []string
+		defaults []int
+		lastDlc string
 	)
+	// Each option maps to the downloads it selects, a DLC heading
+	// selects all of the DLC's items.
+	var optDloads [][]int
 	longestNameLen := getLongestNameLen(downloads)
+	multiPlatform := isMultiPlatform(downloads)
+
+	for i, d := range downloads {
+		if d.DLC != "" && d.DLC != lastDlc {
+			var dlcIndexes []int
+			for j := i; j < len(downloads) && downloads[j].DLC == d.DLC; j++ {
+				dlcIndexes = append(dlcIndexes, j)
+			}
+			opts = append(opts, "== DLC: " + d.DLC + " ==")
+			optDloads = append(optDloads, dlcIndexes)
+		}
+		lastDlc = d.DLC
 
-	for _, d := range downloads {
 		ver := d.Version
 		if ver == "" {
 			ver = "<no ver>"
 		} 
 		spaces := strings.Repeat(" ", longestNameLen-len(d.Name))
-		opts = append(opts, d.Name + spaces + " - " + ver + ", " + d.Size)
+		opt := d.Name + spaces + " - " + ver + ", " + d.Size
+		if multiPlatform && d.Platform != "" {
+			opt += " [" + d.Platform + "]"
+		}
+		if d.Language != "" {
+			opt += " [" + d.Language + "]"
+		}
+		if selectDlc && d.DLC != "" {
+			defaults = append(defaults, len(opts))
+		}
+		opts = append(opts, opt)
+		optDloads = append(optDloads, []int{i})
 	}
 
 	prompt := &survey.MultiSelect{Options: opts}
+	if len(defaults) > 0 {
+		prompt.Default = defaults
+	}
 	err := survey.AskOne(
-		prompt, &indexes, survey.WithValidator(survey.Required),
+		prompt, &optIndexes, survey.WithValidator(survey.Required),
 		survey.WithPageSize(10))
 	if err != nil {
 		return nil, err
 	}
+
+	var indexes []int
+	selected := map[int]bool{}
+	for _, optIdx := range optIndexes {
+		for _, idx := range optDloads[optIdx] {
+			if !selected[idx] {
+				selected[idx] = true
+				indexes = append(indexes, idx)
+			}
+		}
+	}
+	sort.Ints(indexes)
 	return indexes, nil
 }
 
-func selectDownloads(downloads []*Download) ([]*Download, error) {
+func selectDownloads(downloads []*Download, selectDlc bool) ([]*Download, error) {
 	// prodLen := len(products)
 	// if prodLen == 1 {
 	// 	return products[0].ID, nil
 	// }
 	var selectedDloads []*Download
-	indexes, err := getUserDloadIndexes(downloads)
+	indexes, err := getUserDloadIndexes(downloads, selectDlc)
 	if err != nil {
 		return nil, err
 	}
@@ -464,27 +1050,22 @@ func fileExists(path string) (bool, int64, error) {
 	return false, 0, err
 }
 
-func sanitise(fname string) string {
-	regex := regexp.MustCompile(sanRegexStr)
-	fname = regex.ReplaceAllString(fname, "_")
-	return fname
-}
-
-func getFname(itemUrl string) (string, error) {
+func getFname(itemUrl string) (string, int64, error) {
 	req, err := client.Head(itemUrl)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
+	req.Body.Close()
 	if req.StatusCode != http.StatusOK {
-		return "", errors.New(req.Status)
+		return "", 0, errors.New(req.Status)
 	}
 
 	fname := path.Base(req.Request.URL.String())
 	fname, err = url.PathUnescape(fname)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	return fname, nil
+	return fname, req.ContentLength, nil
 }
 
 func getBase(fname string) string {
@@ -496,196 +1077,525 @@ func getBase(fname string) string {
 	return base
 }
 
-func parseTempMeta(title string) map[string]string {
-	parsed := map[string]string{
-		"title": title,
-		"titlePeriods": strings.ReplaceAll(title, " ", "."),
-	}
-	return parsed
-}
-
-func parseTemplate(text string, meta map[string]string) string {
-	var buffer bytes.Buffer
-	for {
-		err := template.Must(template.New("").Parse(text)).Execute(&buffer, meta)
-		if err == nil {
-			break
-		}
-		fmt.Println("Failed to parse template. Default will be used instead.")
-		text = defTemplate
-		buffer.Reset()
-	}
-	return html.UnescapeString(buffer.String())
-}
-
-func downloadItem(download *Download, outPath string) error {
+// Downloads and verifies the item into its .incomplete file and returns it
+// along with the path it belongs at. The incomplete path is empty if the item
+// already exists, unless it's being replaced.
+func fetchItem(cfg *Config, download *Download, outPath string, replace bool) (string, string, error) {
 	var startByte int64
 	itemUrl := download.ManualURL
+	connections := cfg.Connections
 
-	fname, err := getFname(itemUrl)
+	fname, itemSize, err := getFname(itemUrl)
 	if err != nil {
-		return err
+		return "", "", err
 	}
+	fname = getItemFname(cfg, download, fname)
+	download.Fname = fname
 
 	outPath = filepath.Join(outPath, fname)
 	exists, _, err := fileExists(outPath)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	if exists {
-		fmt.Println("Item already exists locally.")
-		return nil
+	if exists && !replace {
+		printLine("Item already exists locally.")
+		return "", outPath, nil
 	}
 
 	base := getBase(outPath)
 	incompPath := filepath.Join(base + ".incomplete")
+	statePath := incompPath + ".state"
 
 	exists, size, err := fileExists(incompPath)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+	// Sequential leftovers have no state file, so finish those as they were.
+	// Chunked ones are always finished chunked, as their .incomplete file is
+	// already full size.
+	stateExists, _, err := fileExists(statePath)
+	if err != nil {
+		return "", "", err
+	}
+	if stateExists {
+		state, err := getResumeState(statePath, exists, itemSize)
+		if err != nil {
+			return "", "", err
+		}
+		if state == nil {
+			printLine("Incomplete item can't be resumed. Starting over...")
+			err = removePartial(incompPath, statePath)
+			if err != nil {
+				return "", "", err
+			}
+			exists, stateExists, size = false, false, 0
+		}
+	}
+	if stateExists || (connections > 1 && itemSize > 0 && !exists) {
+		err = downloadChunked(
+			itemUrl, fname, incompPath, statePath, itemSize, connections)
+		if err == nil {
+			err = verifyPartial(itemUrl, fname, incompPath, statePath)
+			if err != nil {
+				return "", "", err
+			}
+			return incompPath, outPath, nil
+		}
+		// Chunks can't be fetched from a server that ignores ranges, so it's
+		// fetched in one go instead.
+		if !errors.Is(err, errRangeIgnored) {
+			return "", "", err
+		}
+		printLine("Server ignored range request. Starting over...")
+		err = removePartial(incompPath, statePath)
+		if err != nil {
+			return "", "", err
+		}
+		exists = false
 	}
 	if exists {
 		startByte = size
-		fmt.Println("Incomplete item exists locally. Resuming...")
+		printLine("Incomplete item exists locally. Resuming...")
 	}
 
 	req, err := http.NewRequest(http.MethodGet, download.ManualURL, nil)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	req.Header.Add(
 		"Range", "bytes=" + strconv.FormatInt(startByte, 10) + "-")
 
 	do, err := client.Do(req)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	defer do.Body.Close()
 	if do.StatusCode != http.StatusOK && do.StatusCode != http.StatusPartialContent {
-		return errors.New(do.Status)
+		return "", "", errors.New(do.Status)
+	}
+	flags := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	// The whole item's been sent again, appending it would corrupt the partial.
+	if do.StatusCode == http.StatusOK && startByte > 0 {
+		printLine("Server ignored range request. Starting over...")
+		startByte = 0
+		flags |= os.O_TRUNC
 	}
 
-	f, err := os.OpenFile(incompPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0755)
+	f, err := os.OpenFile(incompPath, flags, 0755)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	totalBytes := do.ContentLength + startByte
-	counter := &WriteCounter{
-		Total:     totalBytes,
-		TotalStr:  humanize.Bytes(uint64(totalBytes)),
-		StartTime: time.Now().UnixMilli(),
-		Downloaded: startByte,
-	}
+	counter := newCounter(fname, totalBytes, startByte)
 
 	_, err = io.Copy(f, io.TeeReader(do.Body, counter))
 	f.Close()
-	err = os.Rename(incompPath, outPath)
-	fmt.Println("")
-	return err
+	finishCounter(counter)
+	if err != nil {
+		return "", "", err
+	}
+	err = verifyPartial(itemUrl, fname, incompPath, statePath)
+	if err != nil {
+		return "", "", err
+	}
+	return incompPath, outPath, nil
+}
+
+func downloadItem(cfg *Config, download *Download, outPath string) error {
+	incompPath, fpath, err := fetchItem(cfg, download, outPath, false)
+	if err != nil || incompPath == "" {
+		return err
+	}
+	return os.Rename(incompPath, fpath)
+}
+
+// Games already in the output folder keep their folder, so accounts sharing
+// one library don't download the same game twice. The platform is only used
+// when the template splits platforms.
+func getGamePath(cfg *Config, product *Product, meta *GameMeta, platform string) string {
+	key := libraryKey(product.ID, platform)
+	folder, err := getLibraryFolder(cfg.OutPath, key)
+	if err != nil {
+		handleErr("failed to read library index", err, false)
+	}
+	if folder != "" {
+		return filepath.Join(cfg.OutPath, folder)
+	}
+	templateMeta := parseTempMeta(product, meta, platform)
+	folder, err = renderTemplate(cfg.FolderTmpl, templateMeta)
+	if err != nil {
+		handleErr("failed to render folder template, default will be used", err, false)
+		defTmpl, _ := compileTemplate("default", defTemplate)
+		folder, _ = renderTemplate(defTmpl, templateMeta)
+	}
+	return filepath.Join(cfg.OutPath, sanitise(folder))
+}
+
+// Returns the platform an item's game folder is made for, empty if all
+// platforms share one.
+func getPathPlatform(cfg *Config, item *Download, mainPlatform string) string {
+	if !cfg.SplitPlatforms {
+		return ""
+	}
+	if item.Platform == "" {
+		return mainPlatform
+	}
+	return item.Platform
+}
+
+func getItemDir(gamePath string, item *Download, multiLang, multiPlatform bool) string {
+	itemDir := gamePath
+	if multiPlatform && item.Platform != "" {
+		itemDir = filepath.Join(itemDir, item.Platform)
+	}
+	if item.DLC != "" {
+		itemDir = filepath.Join(itemDir, sanitise(item.DLC))
+	}
+	if multiLang && item.Language != "" {
+		itemDir = filepath.Join(itemDir, sanitise(item.Language))
+	}
+	return itemDir
+}
+
+func getRelDir(gamePath, itemPath string) string {
+	rel, err := filepath.Rel(gamePath, itemPath)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return rel
+}
+
+func processGame(cfg *Config, product *Product, summary *Summary) error {
+	id := product.ID
+	gameMeta, err := getGameMeta(id)
+	if err != nil {
+		return errors.New("failed to get game meta\n" + err.Error())
+	}
+	fmt.Println("--" + gameMeta.Title + "--")
+
+	// DLCs are always offered in the picker, batch mode needs --dlc.
+	downloads, err := parseDownloads(
+		gameMeta, cfg.Platforms, cfg.Languages, cfg.Goodies, cfg.Dlc || !cfg.Batch)
+	if err != nil {
+		return errors.New("failed to parse items\n" + err.Error())
+	}
+	setItemVars(downloads, parseTempMeta(product, gameMeta, ""))
+
+	if !cfg.Batch {
+		downloads, err = selectDownloads(downloads, cfg.Dlc)
+		if err != nil {
+			if err == terminal.InterruptErr {
+				return err
+			}
+			return errors.New("failed to select items\n" + err.Error())
+		}
+	}
+
+	err = checkFnames(cfg, downloads)
+	if err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		return planGame(cfg, product, gameMeta, downloads, summary)
+	}
+
+	itemTotal := len(downloads)
+	mainPlatform := getMainPlatform(downloads)
+	gamePaths := map[string]string{}
+	states := map[string]*GameState{}
+	for _, item := range downloads {
+		platform := getPathPlatform(cfg, item, mainPlatform)
+		if _, ok := gamePaths[platform]; ok {
+			continue
+		}
+		gamePath := getGamePath(cfg, product, gameMeta, platform)
+		err = makeDirs(gamePath)
+		if err != nil {
+			return errors.New("failed to make game folder\n" + err.Error())
+		}
+		err = registerGame(cfg, libraryKey(id, platform), gameMeta.Title, gamePath)
+		if err != nil {
+			handleErr("failed to update library index", err, false)
+		}
+		state, err := readGameState(gamePath, gameMeta, id)
+		if err != nil {
+			return errors.New("failed to read game state\n" + err.Error())
+		}
+		gamePaths[platform] = gamePath
+		states[platform] = state
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+	sem := make(chan struct{}, cfg.Workers)
+	multiLang := isMultiLang(downloads)
+	multiPlatform := isMultiPlatform(downloads) && !cfg.SplitPlatforms
+	for i, item := range downloads {
+		platform := getPathPlatform(cfg, item, mainPlatform)
+		outPath := gamePaths[platform]
+		state := states[platform]
+		itemPath := getItemDir(outPath, item, multiLang, multiPlatform)
+		if itemPath != outPath {
+			err = makeDirs(itemPath)
+			if err != nil {
+				handleErr("failed to make item folder", err, false)
+				mutex.Lock()
+				summary.Failed = append(summary.Failed, gameMeta.Title+" - "+item.Name)
+				mutex.Unlock()
+				continue
+			}
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, item *Download, itemPath, outPath string, state *GameState) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			printLine(fmt.Sprintf("Item %d of %d:\n%s", i+1, itemTotal, item.Name))
+
+			err := downloadItem(cfg, item, itemPath)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				handleErr("failed to download item", err, false)
+				summary.Failed = append(summary.Failed, gameMeta.Title+" - "+item.Name)
+				return
+			}
+			summary.Done++
+			recordItem(state, item, getRelDir(outPath, itemPath))
+			err = writeGameState(outPath, state)
+			if err != nil {
+				handleErr("failed to write game state", err, false)
+			}
+		}(i, item, itemPath, outPath, state)
+	}
+	wg.Wait()
+	if progress != nil {
+		progress.release()
+	}
+	return nil
+}
+
+func printSummary(summary *Summary) {
+	fmt.Printf("\nDone: %d, failed: %d.\n", summary.Done, len(summary.Failed))
+	for _, failed := range summary.Failed {
+		fmt.Println("  " + failed)
+	}
 }
 
 func init() {
-	fmt.Println(`
+	// list keeps stdout for its output.
+	if len(os.Args) > 1 && os.Args[1] == "list" {
+		return
+	}
+	fmt.Print(`
  _____ _____ _____    ____                _           _         
 |   __|     |   __|  |    \ ___ _ _ _ ___| |___ ___ _| |___ ___ 
 |  |  |  |  |  |  |  |  |  | . | | | |   | | . | .'| . | -_|  _|
 |_____|_____|_____|  |____/|___|_____|_|_|_|___|__,|___|___|_|
+
 `)
 }
 
 func main() {
-	scriptDir, err := getScriptDir()
+	cfg, err := parseCfg()
 	if err != nil {
-		panic(err)
+		handleErr("failed to parse config/args", err, true)
 	}
 
-	err = os.Chdir(scriptDir)
-	if err != nil {
-		panic(err)
+	if cfg.Command == "config check" {
+		err = checkConfig(cfg.ConfigPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if cfg.ConfigPath == "" {
+			fmt.Println("Config OK: no config file found, using defaults")
+		} else {
+			fmt.Println("Config OK: " + cfg.ConfigPath)
+		}
+		return
 	}
 
-	cfg, err := parseCfg()
-	if err != nil {
-		handleErr("failed to parse config/args", err, true)
+	if cfg.Command == "template preview" {
+		err = previewTemplates(cfg)
+		if err != nil {
+			handleErr("failed to preview templates", err, true)
+		}
+		return
 	}
 
-	err = makeDirs(cfg.OutPath)
-	if err != nil {
-		handleErr("failed to make output folder(s)", err, true)
+	if cfg.Command == "login" {
+		err = login(cfg)
+		if err != nil {
+			if err == terminal.InterruptErr {
+				os.Exit(0)
+			}
+			handleErr("failed to sign in", err, true)
+		}
+		return
 	}
 
-	cookies, err := readCookies()
-	if err != nil {
-		handleErr("failed to read cookies", err, true)
+	// Status messages go to stderr so the listing can be piped.
+	if cfg.Command == "list" {
+		statusOut = os.Stderr
+	} else if !cfg.DryRun {
+		err = makeDirs(cfg.OutPath)
+		if err != nil {
+			handleErr("failed to make output folder(s)", err, true)
+		}
 	}
 
-	err = setCookies(cookies)
+	var cookies []*Cookie
+	token, err := loadToken(cfg.TokenPath)
 	if err != nil {
-		handleErr("failed to set cookies", err, true)
+		handleErr("failed to load token", err, true)
 	}
+	if token != nil {
+		useToken(token, cfg.TokenPath)
+	} else {
+		cookies, err = readCookies(cfg.CookiesPath)
+		if err != nil {
+			handleErr("failed to read cookies", err, true)
+		}
+
+		err = setCookies(cookies)
+		if err != nil {
+			handleErr("failed to set cookies", err, true)
+		}
+
+		newer, err := isSessionNewer(cfg.CookiesPath, cfg.SessionPath)
+		if err != nil {
+			handleErr("failed to check session cookies", err, true)
+		}
+		if newer {
+			sessionCookies, err := readCookies(cfg.SessionPath)
+			if err != nil {
+				handleErr("failed to read session cookies", err, true)
+			}
+			cookies = append(cookies, sessionCookies...)
+			err = setCookies(sessionCookies)
+			if err != nil {
+				handleErr("failed to set session cookies", err, true)
+			}
+		}
+		// Dry runs leave the session file alone too.
+		if !cfg.DryRun {
+			sessionPath = cfg.SessionPath
+		}
 
-	ok, err := checkCookies()
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			fmt.Fprintln(statusOut, "")
+			exit(130)
+		}()
+	}
+
+	userData, err := checkCookies(cookies)
 	if err != nil {
 		handleErr("failed to check cookies", err, true)
 	}
-	if !ok {
-		panic("bad cookies")
+	// Saves paging through the whole library on quiet nights. list still
+	// prints its empty listing.
+	if cfg.UpdatedOnly && userData.Updates.Products == 0 && cfg.Command != "list" {
+		fmt.Println("No updated products.")
+		exit(0)
 	}
 
-	products, err := search(cfg.Query, cfg.PlatformIDs, cfg.Language)
+	products, err := search(cfg.Query, cfg.PlatformIDs, cfg.Languages, cfg.UpdatedOnly)
 	if err != nil {
 		panic(err)
 	}
+	if cfg.Command == "list" {
+		err = writeList(os.Stdout, products, cfg.ListFormat)
+		if err != nil {
+			handleErr("failed to write list", err, true)
+		}
+		exit(0)
+	}
 	if len(products) == 0 {
 		fmt.Println("No search results.")
-		os.Exit(1)
+		exit(1)
 	}
 
-	id, err := selectGameId(products, cfg.Query)
-	if err != nil {
-		if err == terminal.InterruptErr {
-			os.Exit(0)
+	if cfg.Command == "update" {
+		summary := &Summary{}
+		for _, p := range products {
+			err = updateGame(cfg, &p, summary)
+			if err != nil {
+				handleErr("failed to update "+p.Title, err, false)
+				summary.Failed = append(summary.Failed, p.Title)
+			}
 		}
-		handleErr("failed to select game id", err, true)
-	}
-
-	gameMeta, err := getGameMeta(id)
-	if err != nil {
-		handleErr("failed to get game meta", err, true)
+		label, removedLabel := "Updated", "removed"
+		if cfg.DryRun {
+			label, removedLabel = "To update", "to remove"
+		}
+		fmt.Printf("\n%s: %d, %s: %d, failed: %d.\n",
+			label, summary.Updated, removedLabel, summary.Removed, len(summary.Failed))
+		for _, failed := range summary.Failed {
+			fmt.Println("  " + failed)
+		}
+		if len(summary.Failed) > 0 {
+			exit(1)
+		}
+		exit(0)
 	}
-	fmt.Println("--" + gameMeta.Title + "--")
 
-	downloads, err := parseDownloads(gameMeta, cfg.Platform, cfg.Goodies)
-	if err != nil {
-		handleErr("failed to parse items", err, true)
+	if cfg.Command == "verify" {
+		report := verifyLibrary(cfg, products)
+		printVerifyReport(report)
+		if !report.isClean() {
+			exit(1)
+		}
+		exit(0)
 	}
 
-	downloads, err = selectDownloads(downloads)
-	if err != nil {
-		if err == terminal.InterruptErr {
-			os.Exit(0)
+	if !cfg.Batch {
+		products, err = selectProducts(products, cfg.Query)
+		if err != nil {
+			if err == terminal.InterruptErr {
+				exit(0)
+			}
+			handleErr("failed to select games", err, true)
 		}
-		handleErr("failed to select items", err, true)
 	}
 
-	itemTotal := len(downloads)
-	templateMeta := parseTempMeta(gameMeta.Title)
-	template := parseTemplate(cfg.FolderTemplate, templateMeta)
-
-	outPath := filepath.Join(cfg.OutPath, sanitise(template))
-	err = makeDirs(outPath)
-	if err != nil {
-		handleErr("failed to make game folder", err, true)
+	if cfg.Workers > 1 && !cfg.DryRun {
+		progress = newProgress()
 	}
 
-	for i, item := range downloads {
-		fmt.Printf("Item %d of %d:\n", i+1, itemTotal)
-		fmt.Println(item.Name)
-
-		err = downloadItem(item, outPath)
+	summary := &Summary{}
+	gameTotal := len(products)
+	for i, p := range products {
+		if gameTotal > 1 {
+			fmt.Printf("Game %d of %d:\n", i+1, gameTotal)
+		}
+		err = processGame(cfg, &p, summary)
 		if err != nil {
-			handleErr("failed to download item", err, false)
+			if err == terminal.InterruptErr {
+				exit(0)
+			}
+			// One bad game shouldn't take the rest of the queue down with it.
+			handleErr("failed to process game", err, false)
+			summary.Failed = append(summary.Failed, p.Title)
 		}
 	}
+
+	if cfg.DryRun {
+		printPlan(summary)
+	} else if cfg.Batch || gameTotal > 1 {
+		printSummary(summary)
+	}
+	if len(summary.Failed) > 0 {
+		exit(1)
+	}
+	exit(0)
 }
\ No newline at end of file
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNetscapeCookies(t *testing.T) {
+	data := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		"#HttpOnly_.gog.com\tTRUE\t/\tTRUE\t4102444800\tgog-al\tal-value",
+		".gog.com\tTRUE\t/\tFALSE\t1000000000\told\texpired-value",
+		"www.gog.com\tFALSE\t/account\tFALSE\t0\tgog_us\tsession-value",
+	}, "\r\n")
+	cookies, err := parseNetscapeCookies([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 3 {
+		t.Fatalf("got %d cookies, want 3", len(cookies))
+	}
+
+	al := cookies[0]
+	if al.Name != "gog-al" || al.Value != "al-value" || al.Domain != ".gog.com" {
+		t.Errorf("got %s=%s for %s, want gog-al=al-value for .gog.com", al.Name, al.Value, al.Domain)
+	}
+	if !al.HTTPOnly || !al.Secure || al.HostOnly || al.Session {
+		t.Errorf("gog-al flags: httpOnly %v, secure %v, hostOnly %v, session %v",
+			al.HTTPOnly, al.Secure, al.HostOnly, al.Session)
+	}
+	if al.ExpirationDate != 4102444800 {
+		t.Errorf("gog-al expiry %v, want 4102444800", al.ExpirationDate)
+	}
+
+	old := cookies[1]
+	if old.Name != "old" || old.ExpirationDate != 1000000000 || old.Session {
+		t.Errorf("got %s expiring %v, want the expired cookie kept as is", old.Name, old.ExpirationDate)
+	}
+
+	us := cookies[2]
+	if us.Name != "gog_us" || us.Value != "session-value" || us.Path != "/account" {
+		t.Errorf("got %s=%s for %s, want gog_us=session-value for /account", us.Name, us.Value, us.Path)
+	}
+	if !us.Session || !us.HostOnly || us.HTTPOnly || us.ExpirationDate != 0 {
+		t.Errorf("gog_us flags: session %v, hostOnly %v, httpOnly %v, expiry %v",
+			us.Session, us.HostOnly, us.HTTPOnly, us.ExpirationDate)
+	}
+}
+
+func TestCheckCookieExpiryNetscape(t *testing.T) {
+	data := strings.Join([]string{
+		".gog.com\tTRUE\t/\tTRUE\t1000000000\tgog-al\texpired",
+		".gog.com\tTRUE\t/\tTRUE\t" + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) +
+			"\tgog_us\tsoon",
+	}, "\n")
+	cookies, err := parseNetscapeCookies([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, expiring := checkCookieExpiry(cookies)
+	if len(expired) != 1 || expired[0] != "gog-al" {
+		t.Errorf("expired = %v, want [gog-al]", expired)
+	}
+	if len(expiring) != 1 || !strings.HasPrefix(expiring[0], "gog_us") {
+		t.Errorf("expiring = %v, want gog_us", expiring)
+	}
+}
+
+func TestParseNetscapeCookiesMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"missing fields", "# comment\n.gog.com\tTRUE\t/\tFALSE\t0\tname", "line 2: expected 7"},
+		{"bad expiry", ".gog.com\tTRUE\t/\tFALSE\tsoon\tname\tvalue", "line 1: bad expiry"},
+	}
+	for _, tt := range tests {
+		_, err := parseNetscapeCookies([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Mirrors downloadItem's choice between a fresh, chunked or sequential
+// download. Returns the item's status and how many bytes are left to fetch.
+func getPlanStatus(fpath string, size int64) (string, int64, error) {
+	exists, _, err := fileExists(fpath)
+	if err != nil || exists {
+		return "exists", 0, err
+	}
+	incompPath := getBase(fpath) + ".incomplete"
+	statePath := incompPath + ".state"
+	incompExists, incompSize, err := fileExists(incompPath)
+	if err != nil || !incompExists {
+		return "new", size, err
+	}
+
+	stateExists, _, err := fileExists(statePath)
+	if err != nil {
+		return "", 0, err
+	}
+	if stateExists {
+		state, err := getResumeState(statePath, incompExists, size)
+		if err != nil {
+			return "", 0, err
+		}
+		if state == nil {
+			return "new", size, nil
+		}
+		remaining := size
+		for _, chunk := range state.Chunks {
+			remaining -= chunk.Done
+		}
+		return "resume", remaining, nil
+	}
+	remaining := size - incompSize
+	if remaining < 0 {
+		remaining = 0
+	}
+	return "resume", remaining, nil
+}
+
+// Resolves everything processGame would download without writing anything.
+func planGame(cfg *Config, product *Product, meta *GameMeta, downloads []*Download, summary *Summary) error {
+	mainPlatform := getMainPlatform(downloads)
+	gamePaths := map[string]string{}
+	multiLang := isMultiLang(downloads)
+	multiPlatform := isMultiPlatform(downloads) && !cfg.SplitPlatforms
+	for _, item := range downloads {
+		platform := getPathPlatform(cfg, item, mainPlatform)
+		gamePath, ok := gamePaths[platform]
+		if !ok {
+			gamePath = getGamePath(cfg, product, meta, platform)
+			gamePaths[platform] = gamePath
+		}
+
+		fname, size, err := getFname(item.ManualURL)
+		if err != nil {
+			handleErr("failed to get filename of "+item.Name, err, false)
+			summary.Failed = append(summary.Failed, meta.Title+" - "+item.Name)
+			continue
+		}
+		fname = getItemFname(cfg, item, fname)
+		fpath := filepath.Join(getItemDir(gamePath, item, multiLang, multiPlatform), fname)
+
+		status, remaining, err := getPlanStatus(fpath, size)
+		if err != nil {
+			return errors.New("failed to check " + fpath + "\n" + err.Error())
+		}
+		switch status {
+		case "exists":
+			summary.Exists++
+		case "resume":
+			summary.Resume++
+		default:
+			summary.New++
+		}
+		summary.Bytes += remaining
+		fmt.Printf("%-7s %s (%s of %s)\n", status, fpath,
+			humanize.Bytes(uint64(remaining)), humanize.Bytes(uint64(size)))
+	}
+	return nil
+}
+
+func printPlan(summary *Summary) {
+	fmt.Printf("\nNew: %d, resume: %d, exists: %d, failed: %d. %s to transfer.\n",
+		summary.New, summary.Resume, summary.Exists, len(summary.Failed),
+		humanize.Bytes(uint64(summary.Bytes)))
+	for _, failed := range summary.Failed {
+		fmt.Println("  " + failed)
+	}
+	fmt.Println("Dry run, nothing was downloaded.")
+}
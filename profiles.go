@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const libraryFname = ".gog-dl-library.json"
+
+var libraryMutex sync.Mutex
+
+func getProfileNames(cfg *Config) []string {
+	var names []string
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Profile values go over the top level config ones. Each profile gets its
+// own cookie, session and token files unless it names them itself.
+func applyProfile(cfg *Config, name string) error {
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return errors.New("unknown profile: " + name + ", have: " +
+			strings.Join(getProfileNames(cfg), ", "))
+	}
+	cfg.Profile = name
+	applySettings(cfg, profile)
+
+	cfg.CookiesPath = profile.CookiesPath
+	if cfg.CookiesPath == "" {
+		cfg.CookiesPath = "cookies_" + name + ".json"
+	}
+	cfg.SessionPath = profile.SessionPath
+	if cfg.SessionPath == "" {
+		cfg.SessionPath = "session_" + name + ".json"
+	}
+	cfg.TokenPath = profile.TokenPath
+	if cfg.TokenPath == "" {
+		cfg.TokenPath = "token_" + name + ".json"
+	}
+	return nil
+}
+
+func readLibrary(outPath string) (*Library, error) {
+	data, err := os.ReadFile(filepath.Join(outPath, libraryFname))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Library{Games: map[string]*LibraryGame{}}, nil
+		}
+		return nil, err
+	}
+	var obj Library
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Games == nil {
+		obj.Games = map[string]*LibraryGame{}
+	}
+	return &obj, nil
+}
+
+func writeLibrary(outPath string, library *Library) error {
+	data, err := json.MarshalIndent(library, "", "\t")
+	if err != nil {
+		return err
+	}
+	libraryPath := filepath.Join(outPath, libraryFname)
+	err = os.WriteFile(libraryPath+".tmp", data, 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(libraryPath+".tmp", libraryPath)
+}
+
+// Games split into per platform folders get an entry for each platform.
+func libraryKey(id int, platform string) string {
+	key := strconv.Itoa(id)
+	if platform != "" {
+		key += "-" + platform
+	}
+	return key
+}
+
+// Returns the folder a game already has in the output folder, which may have
+// been made by another profile with a different template.
+func getLibraryFolder(outPath, key string) (string, error) {
+	libraryMutex.Lock()
+	defer libraryMutex.Unlock()
+	library, err := readLibrary(outPath)
+	if err != nil {
+		return "", err
+	}
+	game, ok := library.Games[key]
+	if !ok {
+		return "", nil
+	}
+	return game.Folder, nil
+}
+
+func registerGame(cfg *Config, key, title, gamePath string) error {
+	libraryMutex.Lock()
+	defer libraryMutex.Unlock()
+	library, err := readLibrary(cfg.OutPath)
+	if err != nil {
+		return err
+	}
+	folder, err := filepath.Rel(cfg.OutPath, gamePath)
+	if err != nil {
+		return err
+	}
+
+	game, ok := library.Games[key]
+	if !ok {
+		game = &LibraryGame{Title: title, Folder: folder}
+		library.Games[key] = game
+	}
+	profile := cfg.Profile
+	if profile == "" {
+		profile = "default"
+	}
+	for _, p := range game.Profiles {
+		if p == profile {
+			return nil
+		}
+	}
+	game.Profiles = append(game.Profiles, profile)
+	return writeLibrary(cfg.OutPath, library)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mattn/go-isatty"
+)
+
+const renderInterval = 250 * time.Millisecond
+
+// Set when more than one worker is running. The single worker path keeps
+// printing WriteCounter's \r line as before.
+var progress *Progress
+
+// The block's redrawn with ANSI cursor codes, which show up raw when stdout's
+// a file or pipe, like under cron, and on consoles without VT processing.
+func isAnsiTerminal(f *os.File) bool {
+	if isatty.IsCygwinTerminal(f.Fd()) {
+		return true
+	}
+	return isatty.IsTerminal(f.Fd()) && enableAnsi(f)
+}
+
+func newProgress() *Progress {
+	return &Progress{StartTime: time.Now().UnixMilli(), Plain: !isAnsiTerminal(os.Stdout)}
+}
+
+func newCounter(name string, total, downloaded int64) *WriteCounter {
+	counter := &WriteCounter{
+		Name:       name,
+		Total:      total,
+		TotalStr:   humanize.Bytes(uint64(total)),
+		StartTime:  time.Now().UnixMilli(),
+		Downloaded: downloaded,
+		Progress:   progress,
+	}
+	if progress != nil {
+		progress.add(counter)
+	}
+	return counter
+}
+
+func finishCounter(counter *WriteCounter) {
+	if counter.Progress == nil {
+		fmt.Println("")
+		return
+	}
+	counter.Progress.remove(counter)
+}
+
+// Goes above the progress block when one's being drawn.
+func printLine(line string) {
+	if progress == nil {
+		fmt.Fprintln(statusOut, line)
+		return
+	}
+	progress.Mutex.Lock()
+	defer progress.Mutex.Unlock()
+	progress.clear()
+	fmt.Println(line)
+	progress.render()
+}
+
+func (p *Progress) add(counter *WriteCounter) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	p.Counters = append(p.Counters, counter)
+	if p.Plain {
+		fmt.Printf("Downloading %s (%s)\n", counter.Name, counter.TotalStr)
+		return
+	}
+	p.redraw()
+}
+
+func (p *Progress) remove(counter *WriteCounter) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	for i, c := range p.Counters {
+		if c == counter {
+			p.Counters = append(p.Counters[:i], p.Counters[i+1:]...)
+			break
+		}
+	}
+	p.Completed++
+	if p.Plain {
+		fmt.Printf("Finished %s, %d active, %d completed\n",
+			counter.Name, len(p.Counters), p.Completed)
+		return
+	}
+	p.redraw()
+}
+
+func (p *Progress) update(counter *WriteCounter, n int) {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	counter.Downloaded += int64(n)
+	p.Transferred += int64(n)
+	now := time.Now().UnixMilli()
+	if now-p.LastRender < renderInterval.Milliseconds() {
+		return
+	}
+	p.LastRender = now
+	p.redraw()
+}
+
+// Leaves the last drawn block on screen so prompts and other output can
+// follow it without getting cleared.
+func (p *Progress) release() {
+	p.Mutex.Lock()
+	defer p.Mutex.Unlock()
+	p.Lines = 0
+}
+
+func (p *Progress) clear() {
+	if p.Lines > 0 && !p.Plain {
+		fmt.Printf("\033[%dA\033[J", p.Lines)
+		p.Lines = 0
+	}
+}
+
+func (p *Progress) redraw() {
+	p.clear()
+	p.render()
+}
+
+func (p *Progress) render() {
+	if p.Plain {
+		return
+	}
+	var (
+		speed      int64
+		downloaded int64
+		total      int64
+		lines      []string
+	)
+	for _, c := range p.Counters {
+		percentage := 0
+		if c.Total > 0 {
+			percentage = int(float64(c.Downloaded) / float64(c.Total) * float64(100))
+		}
+		lines = append(lines, fmt.Sprintf("%3d%% %s/%s %s", percentage,
+			humanize.Bytes(uint64(c.Downloaded)), c.TotalStr, c.Name))
+		downloaded += c.Downloaded
+		total += c.Total
+	}
+	toDivideBy := time.Now().UnixMilli() - p.StartTime
+	if toDivideBy != 0 {
+		speed = p.Transferred / toDivideBy * 1000
+	}
+	lines = append(lines, fmt.Sprintf(
+		"Active: %d, completed: %d, %s/%s @ %s/s", len(p.Counters), p.Completed,
+		humanize.Bytes(uint64(downloaded)), humanize.Bytes(uint64(total)),
+		humanize.Bytes(uint64(speed))))
+	fmt.Println(strings.Join(lines, "\n"))
+	p.Lines = len(lines)
+}
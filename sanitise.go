@@ -0,0 +1,112 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// Per path component on NTFS, ext4 and most others.
+	maxNameBytes = 255
+	// Longest suffix added to downloads while they're in progress, on the
+	// chunk state's temp file.
+	partialSuffix = ".incomplete.state.tmp"
+	// Anything after the last dot longer than this isn't an extension.
+	maxExtLen = 16
+)
+
+var sanitisePolicies = []string{"windows-safe", "posix", "ascii-only"}
+
+// Set from the config, used for every folder and file name.
+var sanitisePolicy = "windows-safe"
+
+var (
+	windowsRegex  = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+	posixRegex    = regexp.MustCompile(`[/\x00-\x1f]`)
+	reservedRegex = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[1-9]|lpt[1-9])(\..*)?$`)
+)
+
+func isSanitisePolicy(policy string) bool {
+	for _, p := range sanitisePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// Accents are dropped, anything else outside of ASCII is replaced.
+func toAscii(name string) string {
+	var builder strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if r > unicode.MaxASCII {
+			r = '_'
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// Cuts the name down to maxLen bytes without splitting a character,
+// keeping the extension.
+func truncateName(name string, maxLen int) string {
+	if len(name) <= maxLen {
+		return name
+	}
+	ext := getExt(name)
+	if len(ext) > maxExtLen || strings.Contains(ext, " ") {
+		ext = ""
+	}
+	cut := maxLen - len(ext)
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + ext
+}
+
+func sanitiseName(name string, maxLen int) string {
+	if sanitisePolicy == "posix" {
+		name = posixRegex.ReplaceAllString(name, "_")
+	} else {
+		if sanitisePolicy == "ascii-only" {
+			name = toAscii(name)
+		}
+		name = windowsRegex.ReplaceAllString(name, "_")
+	}
+	name = truncateName(name, maxLen)
+
+	// Windows drops trailing dots and spaces and won't make files named
+	// after devices, even with an extension.
+	if sanitisePolicy != "posix" {
+		name = strings.TrimRight(name, ". ")
+		if reservedRegex.MatchString(name) {
+			dot := strings.Index(name, ".")
+			if dot == -1 {
+				name += "_"
+			} else {
+				name = name[:dot] + "_" + name[dot:]
+			}
+		}
+	}
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// For folder names.
+func sanitise(name string) string {
+	return sanitiseName(name, maxNameBytes)
+}
+
+// Downloaded files leave room for the suffix of their partial files.
+func sanitiseFname(fname string) string {
+	return sanitiseName(fname, maxNameBytes-len(partialSuffix))
+}
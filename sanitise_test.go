@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitiseName(t *testing.T) {
+	tests := []struct {
+		policy string
+		name   string
+		want   string
+	}{
+		{"windows-safe", "The Witcher: Enhanced Edition", "The Witcher_ Enhanced Edition"},
+		{"windows-safe", `a<b>c"d/e\f|g?h*i`, "a_b_c_d_e_f_g_h_i"},
+		{"windows-safe", "CON", "CON_"},
+		{"windows-safe", "nul.txt", "nul_.txt"},
+		{"windows-safe", "com1.tar.gz", "com1_.tar.gz"},
+		{"windows-safe", "Console", "Console"},
+		{"windows-safe", "Wait...", "Wait"},
+		{"windows-safe", "trailing . ", "trailing"},
+		{"windows-safe", "...", "_"},
+		{"posix", "What: Why?", "What: Why?"},
+		{"posix", "a/b", "a_b"},
+		{"posix", "CON", "CON"},
+		{"posix", "Wait...", "Wait..."},
+		{"ascii-only", "Pokémon: Ōkami", "Pokemon_ Okami"},
+		{"ascii-only", "東方", "__"},
+	}
+	defer func(policy string) { sanitisePolicy = policy }(sanitisePolicy)
+	for _, tt := range tests {
+		sanitisePolicy = tt.policy
+		got := sanitiseName(tt.name, maxNameBytes)
+		if got != tt.want {
+			t.Errorf("%s: sanitiseName(%q) = %q, want %q", tt.policy, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxLen int
+		want   string
+	}{
+		{"short.exe", 20, "short.exe"},
+		{"setup_game_1.0.exe", 10, "setup_.exe"},
+		{"game_linux_1.0.tar.gz", 15, "game_lin.tar.gz"},
+		// 3 byte characters, the cut can't land mid-character.
+		{"東方東方.exe", 10, "東方.exe"},
+		{"東方東方.exe", 9, "東.exe"},
+		// Not an extension, so it's cut like the rest.
+		{"Version 1.2 final release", 12, "Version 1.2 "},
+	}
+	for _, tt := range tests {
+		got := truncateName(tt.name, tt.maxLen)
+		if got != tt.want {
+			t.Errorf("truncateName(%q, %d) = %q, want %q", tt.name, tt.maxLen, got, tt.want)
+		}
+	}
+}
+
+func TestSanitiseFnameLength(t *testing.T) {
+	defer func(policy string) { sanitisePolicy = policy }(sanitisePolicy)
+	sanitisePolicy = "windows-safe"
+	names := []string{
+		strings.Repeat("a", 300) + ".exe",
+		strings.Repeat("é", 200) + ".tar.gz",
+		strings.Repeat("東", 100) + ".bin",
+	}
+	for _, name := range names {
+		got := sanitiseFname(name)
+		if !utf8.ValidString(got) {
+			t.Errorf("sanitiseFname cut %q mid-character", got)
+		}
+		// Every partial file made next to it has to fit too.
+		if len(got+partialSuffix) > maxNameBytes {
+			t.Errorf("sanitiseFname gave %d bytes, no room for %s", len(got), partialSuffix)
+		}
+		if getExt(got) != getExt(name) {
+			t.Errorf("sanitiseFname dropped the extension: %q", got)
+		}
+	}
+}
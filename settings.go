@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const envPrefix = "GOGDL_"
+
+// The config file is unmarshalled straight into Config, then parseCfg applies
+// the profile, env vars and args over it in that order.
+func applySettings(cfg *Config, s *Settings) {
+	if s.Platform != "" {
+		cfg.Platform = s.Platform
+	}
+	if s.Language != "" {
+		cfg.Language = s.Language
+	}
+	if s.FolderTemplate != "" {
+		cfg.FolderTemplate = s.FolderTemplate
+	}
+	if s.FileTemplate != "" {
+		cfg.FileTemplate = s.FileTemplate
+	}
+	if s.Sanitise != "" {
+		cfg.Sanitise = s.Sanitise
+	}
+	if s.Goodies != nil {
+		cfg.Goodies = *s.Goodies
+	}
+	if s.Dlc != nil {
+		cfg.Dlc = *s.Dlc
+	}
+	if s.OutPath != "" {
+		cfg.OutPath = s.OutPath
+	}
+	if s.CookiesPath != "" {
+		cfg.CookiesPath = s.CookiesPath
+	}
+	if s.SessionPath != "" {
+		cfg.SessionPath = s.SessionPath
+	}
+	if s.TokenPath != "" {
+		cfg.TokenPath = s.TokenPath
+	}
+	if s.Batch != nil {
+		cfg.Batch = *s.Batch
+	}
+	if s.UpdatedOnly != nil {
+		cfg.UpdatedOnly = *s.UpdatedOnly
+	}
+	if s.Connections != 0 {
+		cfg.Connections = s.Connections
+	}
+	if s.Workers != 0 {
+		cfg.Workers = s.Workers
+	}
+}
+
+func getEnv(name string) string {
+	return strings.TrimSpace(os.Getenv(envPrefix + name))
+}
+
+func getEnvBool(name string) (*bool, error) {
+	value := getEnv(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.New(envPrefix + name + " must be true or false, got: " + value)
+	}
+	return &parsed, nil
+}
+
+func getEnvInt(name string) (int, error) {
+	value := getEnv(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New(envPrefix + name + " must be a number, got: " + value)
+	}
+	return parsed, nil
+}
+
+func getEnvSettings() (*Settings, error) {
+	var err error
+	s := &Settings{
+		Platform:       getEnv("PLATFORM"),
+		Language:       getEnv("LANGUAGE"),
+		FolderTemplate: getEnv("FOLDER_TEMPLATE"),
+		FileTemplate:   getEnv("FILE_TEMPLATE"),
+		Sanitise:       getEnv("SANITISE"),
+		OutPath:        getEnv("OUT_PATH"),
+		CookiesPath:    getEnv("COOKIES_PATH"),
+		SessionPath:    getEnv("SESSION_PATH"),
+		TokenPath:      getEnv("TOKEN_PATH"),
+	}
+	s.Goodies, err = getEnvBool("GOODIES")
+	if err != nil {
+		return nil, err
+	}
+	s.Dlc, err = getEnvBool("DLC")
+	if err != nil {
+		return nil, err
+	}
+	s.Batch, err = getEnvBool("BATCH")
+	if err != nil {
+		return nil, err
+	}
+	s.UpdatedOnly, err = getEnvBool("UPDATED_ONLY")
+	if err != nil {
+		return nil, err
+	}
+	s.Connections, err = getEnvInt("CONNECTIONS")
+	if err != nil {
+		return nil, err
+	}
+	s.Workers, err = getEnvInt("WORKERS")
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Bool flags can only switch things on.
+func getArgSettings(args *Args) *Settings {
+	var on = true
+	s := &Settings{
+		Platform:       args.Platform,
+		Language:       args.Language,
+		FolderTemplate: args.FolderTemplate,
+		FileTemplate:   args.FileTemplate,
+		Sanitise:       args.Sanitise,
+		OutPath:        args.OutPath,
+		CookiesPath:    args.CookiesPath,
+		Connections:    args.Connections,
+		Workers:        args.Workers,
+	}
+	if args.Goodies {
+		s.Goodies = &on
+	}
+	if args.Dlc {
+		s.Dlc = &on
+	}
+	if args.Batch || args.Yes {
+		s.Batch = &on
+	}
+	if args.UpdatedOnly {
+		s.UpdatedOnly = &on
+	}
+	return s
+}
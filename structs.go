@@ -1,5 +1,12 @@
 package main
 
+import (
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+)
+
 type Transport struct{}
 
 type Config struct {
@@ -7,18 +14,148 @@ type Config struct {
 	Platform   	   string
 	Language 	   string
 	FolderTemplate string
+	FileTemplate   string
+	Sanitise	   string
 	Goodies		   bool
+	Dlc			   bool
 	OutPath        string
+	CookiesPath	   string
+	SessionPath	   string
+	TokenPath	   string
+	Batch		   bool
+	UpdatedOnly	   bool
+	Connections	   int
+	Workers		   int
+	Command		   string
+	Repair		   bool
+	Archive		   bool
+	AuthCode	   string
+	Profile		   string
+	Profiles	   map[string]*Settings
+	ConfigPath	   string
 	PlatformIDs	   string
+	Platforms	   []string
+	SplitPlatforms bool
+	Languages	   []string
+	ListFormat	   string
+	DryRun		   bool
+	FolderTmpl	   *template.Template
+	FileTmpl	   *template.Template
+}
+
+// A layer of config values, used for profiles, env vars and args. Bools are
+// pointers so a layer can switch something off again.
+type Settings struct {
+	Platform       string
+	Language       string
+	FolderTemplate string
+	FileTemplate   string
+	Sanitise       string
+	Goodies        *bool
+	Dlc            *bool
+	OutPath        string
+	CookiesPath    string
+	SessionPath    string
+	TokenPath      string
+	Batch          *bool
+	UpdatedOnly    *bool
+	Connections    int
+	Workers        int
+}
+
+type Library struct {
+	Games map[string]*LibraryGame `json:"games"`
+}
+
+type LibraryGame struct {
+	Title    string   `json:"title"`
+	Folder   string   `json:"folder"`
+	Profiles []string `json:"profiles"`
 }
 
 type Args struct {
-	Query    	   string `arg:"positional"`
-	Platform 	   string `arg:"-p, --platform" help:"Item platform. windows/win, linux, mac/osx."`
-	Language 	   string `arg:"-l, --language" help:"Item language.\n\t\t\t en, cz, de, es, fr, it, hu, nl, pl, pt, br, sv, tr, uk, ru, ar, ko, cn, jp, all."`
-	FolderTemplate string `arg:"-t, --template" help:"Game folder naming template. title, titlePeriods.\n\t\t\t Ex: {{.title}} [GOG], {{.titlePeriods}}.GOG"`
+	Query    	   string   `arg:"positional"`
+	Options
+	Cmd			   *CmdArgs `arg:"-"`
+}
+
+// Shared by the default download mode and the commands.
+type Options struct {
+	ConfigPath	   string `arg:"--config" help:"Config file. Looked for in $XDG_CONFIG_HOME/gog-downloader, ~/.config/gog-downloader and next to the binary if not given."`
+	Profile		   string `arg:"-P, --profile" help:"Account profile from the config file to use."`
+	Platform 	   string `arg:"-p, --platform" help:"Item platform(s), comma-separated. windows/win, linux, mac/osx, all."`
+	Language 	   string `arg:"-l, --language" help:"Item language(s), comma-separated.\n\t\t\t en, cz, de, es, fr, it, hu, nl, pl, pt, br, sv, tr, uk, ru, ar, ko, cn, jp, all."`
+	FolderTemplate string `arg:"-t, --template" help:"Game folder naming template. title, titlePeriods, titleSafe, id, slug, year, category, platform.\n\t\t\t Ex: {{.title}} [GOG], {{.titlePeriods}}.GOG, {{.title}} ({{.year}}) [{{.platform}}]"`
+	FileTemplate   string `arg:"--file-template" help:"Downloaded file naming template, the extension is kept. Folder template variables plus language, version, date, type, name, fname.\n\t\t\t Ex: {{.slug}}_{{.version}}_{{.fname}}"`
+	Sanitise	   string `arg:"--sanitise" help:"Folder and file name sanitisation. windows-safe, posix, ascii-only."`
 	Goodies 	   bool	  `arg:"-g, --goodies" help:"Include goodies."`
+	Dlc			   bool	  `arg:"-d, --dlc" help:"Include DLCs. Each goes into its own subfolder in the game folder."`
 	OutPath  	   string `arg:"-o, --out-path" help:"Where to download to. Path will be made if it doesn't already exist."`
+	CookiesPath	   string `arg:"--cookies" help:"Cookies file. EditThisCookie JSON or Netscape cookies.txt."`
+	Batch		   bool	  `arg:"-a, --all" help:"Batch mode. Download every result and item without prompting."`
+	Yes			   bool	  `arg:"-y, --yes" help:"Same as --all."`
+	UpdatedOnly	   bool	  `arg:"-u, --updated-only" help:"Only include products GOG marks as updated."`
+	Connections	   int	  `arg:"-c, --connections" help:"Connections per item. Each item is split into this many concurrently fetched byte ranges."`
+	Workers		   int	  `arg:"-w, --workers" help:"How many items to download at once."`
+	DryRun		   bool	  `arg:"-n, --dry-run" help:"Resolve and print what would be downloaded, with sizes, without writing anything."`
+}
+
+type CmdArgs struct {
+	Options
+	Verify *VerifyCmd `arg:"subcommand:verify" help:"Check downloaded items against GOG's checksums."`
+	Update *UpdateCmd `arg:"subcommand:update" help:"Redownload items that changed since they were downloaded."`
+	Login  *LoginCmd  `arg:"subcommand:login" help:"Sign in to GOG and store a refresh token instead of using cookies."`
+	Config *ConfigCmd `arg:"subcommand:config" help:"Config file tools."`
+	Template *TemplateCmd `arg:"subcommand:template" help:"Template tools."`
+	List   *ListCmd   `arg:"subcommand:list" help:"Print owned products matching the query without downloading anything."`
+}
+
+type ListCmd struct {
+	Query  string `arg:"positional"`
+	Format string `arg:"-f, --format" help:"Output format. table, json or csv. Defaults to table."`
+}
+
+type TemplateCmd struct {
+	Preview *TemplatePreviewCmd `arg:"subcommand:preview" help:"Render the folder and file templates against a sample game. Takes -t and --file-template."`
+}
+
+type TemplatePreviewCmd struct{}
+
+type ConfigCmd struct {
+	Check *ConfigCheckCmd `arg:"subcommand:check" help:"Validate the config file and GOGDL_* env vars."`
+}
+
+type ConfigCheckCmd struct{}
+
+type VerifyCmd struct {
+	Query  string `arg:"positional"`
+	Repair bool   `arg:"-r, --repair" help:"Redownload missing, corrupt and wrong size items."`
+}
+
+type LoginCmd struct {
+	Code string `arg:"positional" help:"Code or redirect URL. Asked for if not given."`
+}
+
+type UpdateCmd struct {
+	Query   string `arg:"positional"`
+	Archive bool   `arg:"--archive" help:"Move replaced items into an archive folder in the game folder instead of deleting them."`
+}
+
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	SessionID    string `json:"session_id"`
+	RefreshToken string `json:"refresh_token"`
+	UserID       string `json:"user_id"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+type PersistentJar struct {
+	Jar    http.CookieJar
+	Mutex  sync.Mutex
+	Stored map[string]*Cookie
 }
 
 type Cookie struct {
@@ -149,10 +286,10 @@ type GameMeta struct {
 	BackgroundImage        string          `json:"backgroundImage"`
 	CdKey                  string          `json:"cdKey"`
 	TextInformation        string          `json:"textInformation"`
-	Downloads              [][]interface{} `json:"downloads"`
+	Downloads              []*LangDownloads `json:"downloads"`
 	GalaxyDownloads        []interface{}   `json:"galaxyDownloads"`
 	Extras          	   []*Download      `json:"extras"`
-	Dlcs                   []interface{}   `json:"dlcs"`
+	Dlcs                   []*GameMeta     `json:"dlcs"`
 	Tags                   []interface{}   `json:"tags"`
 	IsPreOrder             bool            `json:"isPreOrder"`
 	ReleaseTimestamp       int             `json:"releaseTimestamp"`
@@ -168,6 +305,12 @@ type GameMeta struct {
 	} `json:"simpleGalaxyInstallers"`
 }
 
+// Decoded from GOG's ["English", {"windows": [...], "linux": [...]}] pairs.
+type LangDownloads struct {
+	Language  string
+	Platforms map[string][]*Download
+}
+
 type Download struct {
 	ManualURL string `json:"manualUrl"`
 	Name      string `json:"name"`
@@ -175,9 +318,134 @@ type Download struct {
 	Date      string `json:"date"`
 	Size      string `json:"size"`
 	Type      string `json:"type"`
+	Language  string `json:"-"`
+	Platform  string `json:"-"`
+	Fname     string `json:"-"`
+	DLC       string `json:"-"`
+	Vars      map[string]string `json:"-"`
+}
+
+type GameState struct {
+	ID    int                   `json:"id"`
+	Title string                `json:"title"`
+	Items map[string]*ItemState `json:"items"`
+}
+
+type ItemState struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Date     string `json:"date"`
+	Size     string `json:"size"`
+	Fname    string `json:"fname"`
+	Dir      string `json:"dir,omitempty"`
+	Language string `json:"language,omitempty"`
+	Platform string `json:"platform,omitempty"`
+}
+
+type VerifyReport struct {
+	OK        int
+	Repaired  []string
+	Missing   []string
+	WrongSize []string
+	Corrupt   []string
+	Stale     []string
+	Failed    []string
+	// Game folders in the output folder the search didn't cover.
+	Unchecked []string
+	Checked   map[string]bool
+}
+
+type ListEntry struct {
+	ID          int      `json:"id"`
+	Title       string   `json:"title"`
+	Slug        string   `json:"slug"`
+	Category    string   `json:"category"`
+	Platforms   []string `json:"platforms"`
+	DlcCount    int      `json:"dlcCount"`
+	ReleaseDate string   `json:"releaseDate"`
+	Rating      int      `json:"rating"`
+	Updated     bool     `json:"updated"`
+}
+
+type TemplateError struct {
+	Name string
+	Text string
+	Line int
+	// 0-based, -1 if unknown.
+	Col int
+	Msg string
+}
+
+type ConfigError struct {
+	Path     string
+	Problems []string
+}
+
+type Summary struct {
+	Done   int
+	Updated int
+	Removed int
+	Failed []string
+	// Dry run counts.
+	New    int
+	Resume int
+	Exists int
+	Bytes  int64
+}
+
+type Downlink struct {
+	Downlink string `json:"downlink"`
+	Checksum string `json:"checksum"`
+}
+
+type FileChecksum struct {
+	Name      string           `xml:"name,attr"`
+	MD5       string           `xml:"md5,attr"`
+	TotalSize int64            `xml:"total_size,attr"`
+	Chunks    []*ChunkChecksum `xml:"chunk"`
+}
+
+type ChunkChecksum struct {
+	ID     int    `xml:"id,attr"`
+	From   int64  `xml:"from,attr"`
+	To     int64  `xml:"to,attr"`
+	Method string `xml:"method,attr"`
+	Hash   string `xml:",chardata"`
+}
+
+type ChunkState struct {
+	Size   int64    `json:"size"`
+	Chunks []*Chunk `json:"chunks"`
+}
+
+type Chunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+type ChunkWriter struct {
+	File    *os.File
+	Chunk   *Chunk
+	Counter *WriteCounter
+	Mutex   *sync.Mutex
+}
+
+type Progress struct {
+	Mutex       sync.Mutex
+	Counters    []*WriteCounter
+	Completed   int
+	Transferred int64
+	StartTime   int64
+	LastRender  int64
+	Lines       int
+	// No cursor movement, items get a line as they start and finish.
+	Plain       bool
 }
 
 type WriteCounter struct {
+	Name       string
+	Progress   *Progress
 	Total      int64
 	TotalStr   string
 	Downloaded int64
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Only set for items, so they're empty in the folder template.
+var itemVarNames = []string{
+	"language", "version", "date", "type", "name", "fname", "ext",
+}
+
+// Extensions made of more than one part, kept whole by the file template.
+var multiExts = []string{".tar.gz", ".tar.bz2", ".tar.xz"}
+
+// template: name:line: msg or template: name:line:col: executing "name" at <.x>: msg
+var tmplErrRegex = regexp.MustCompile(
+	`^template: [^:]*:(\d+):(?:(\d+):)? (?:executing "[^"]*" at <[^>]*>: )?(.*)$`)
+
+// Only valid inside other actions, so they can't be parsed on their own.
+var tmplKeywords = []string{
+	"if", "else", "end", "range", "with", "define", "block", "template",
+	"break", "continue",
+}
+
+// Drops accents and anything else outside of ASCII.
+func foldAscii(str string) string {
+	var builder strings.Builder
+	for _, r := range norm.NFD.String(str) {
+		if unicode.Is(unicode.Mn, r) || r > unicode.MaxASCII {
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return strings.TrimSpace(builder.String())
+}
+
+func getReleaseYear(product *Product, meta *GameMeta) string {
+	if meta.ReleaseTimestamp > 0 {
+		return strconv.Itoa(time.Unix(int64(meta.ReleaseTimestamp), 0).UTC().Year())
+	}
+	// 2016-02-26 00:00:00.000000
+	date := product.ReleaseDate.Date
+	if len(date) >= 4 {
+		return date[:4]
+	}
+	return ""
+}
+
+func parseTempMeta(product *Product, meta *GameMeta, platform string) map[string]string {
+	title := meta.Title
+	if title == "" {
+		title = product.Title
+	}
+	parsed := map[string]string{
+		"title":        title,
+		"titlePeriods": strings.ReplaceAll(title, " ", "."),
+		"titleSafe":    foldAscii(sanitise(title)),
+		"id":           strconv.Itoa(product.ID),
+		"slug":         product.Slug,
+		"year":         getReleaseYear(product, meta),
+		"category":     product.Category,
+		"platform":     platform,
+	}
+	for _, name := range itemVarNames {
+		parsed[name] = ""
+	}
+	return parsed
+}
+
+// Parse errors only give the line, so each action is parsed on its own to
+// find the one at fault. Returns -1 if none fails alone.
+func findBadAction(text string) int {
+	for offset := 0; ; {
+		start := strings.Index(text[offset:], "{{")
+		if start == -1 {
+			return -1
+		}
+		start += offset
+		end := strings.Index(text[start:], "}}")
+		if end == -1 {
+			return start
+		}
+		end += start + 2
+		offset = end
+
+		action := strings.TrimSpace(strings.Trim(text[start:end], "{}-"))
+		keyword := strings.Fields(action + " ")
+		if len(keyword) > 0 && contains(tmplKeywords, keyword[0]) {
+			continue
+		}
+		_, err := template.New("").Parse(text[start:end])
+		if err != nil {
+			return start
+		}
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func newTemplateError(name, text string, err error) *TemplateError {
+	tmplErr := &TemplateError{Name: name, Text: text, Msg: err.Error(), Col: -1}
+	match := tmplErrRegex.FindStringSubmatch(err.Error())
+	if match == nil {
+		return tmplErr
+	}
+	tmplErr.Line, _ = strconv.Atoi(match[1])
+	tmplErr.Msg = match[3]
+	if match[2] != "" {
+		tmplErr.Col, _ = strconv.Atoi(match[2])
+		return tmplErr
+	}
+	offset := findBadAction(text)
+	if offset == -1 {
+		return tmplErr
+	}
+	lineStart := strings.LastIndex(text[:offset], "\n") + 1
+	tmplErr.Line = strings.Count(text[:offset], "\n") + 1
+	tmplErr.Col = offset - lineStart
+	return tmplErr
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line == 0 {
+		return e.Name + ": " + e.Msg
+	}
+	if e.Col == -1 {
+		return fmt.Sprintf("%s: line %d: %s", e.Name, e.Line, e.Msg)
+	}
+	line := strings.Split(e.Text, "\n")[e.Line-1]
+	return fmt.Sprintf("%s: line %d, col %d: %s\n    %s\n    %s^",
+		e.Name, e.Line, e.Col+1, e.Msg, line, strings.Repeat(" ", e.Col))
+}
+
+// Parsed once when the config's read. Unknown variables are errors and are
+// caught here by rendering against the sample game.
+func compileTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, newTemplateError(name, text, err)
+	}
+	_, downloads := getSampleGame()
+	_, err = renderTemplate(tmpl, downloads[0].Vars)
+	if err != nil {
+		return nil, newTemplateError(name, text, err)
+	}
+	return tmpl, nil
+}
+
+func renderTemplate(tmpl *template.Template, vars map[string]string) (string, error) {
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, vars)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// With {{.platform}} in the folder template each platform gets its own game
+// folder, otherwise they share one and get platform subfolders.
+func splitsPlatforms(tmpl *template.Template) bool {
+	product, meta := &Product{}, &GameMeta{}
+	windows, _ := renderTemplate(tmpl, parseTempMeta(product, meta, "windows"))
+	linux, _ := renderTemplate(tmpl, parseTempMeta(product, meta, "linux"))
+	return windows != linux
+}
+
+// Used to check templates and by template preview.
+func getSampleGame() (map[string]string, []*Download) {
+	product := &Product{
+		ID:       1207658924,
+		Title:    "The Witcher: Enhanced Edition",
+		Slug:     "the_witcher",
+		Category: "Role-playing",
+	}
+	meta := &GameMeta{
+		Title:            product.Title,
+		ReleaseTimestamp: 1193353200,
+	}
+	downloads := []*Download{
+		{
+			Name:     product.Title,
+			Version:  "1.5 (gog-3)",
+			Date:     "2021-08-26",
+			Language: "English",
+			Platform: "windows",
+			Fname:    "setup_the_witcher_enhanced_edition_1.5_(a)_(10712).exe",
+		},
+		{
+			Name:     product.Title,
+			Version:  "1.5 (gog-3)",
+			Date:     "2021-08-26",
+			Language: "English",
+			Platform: "windows",
+			Fname:    "setup_the_witcher_enhanced_edition_1.5_(a)_(10712)-1.bin",
+		},
+		{
+			Name:  "manual",
+			Type:  "manuals",
+			Fname: "the_witcher_manual_en.zip",
+		},
+	}
+	gameVars := parseTempMeta(product, meta, "")
+	setItemVars(downloads, gameVars)
+	return gameVars, downloads
+}
+
+func getItemType(item *Download) string {
+	if item.Platform == "" {
+		return "goodie"
+	}
+	if item.DLC != "" {
+		return "dlc"
+	}
+	if strings.Contains(strings.ToLower(item.Name), "patch") {
+		return "patch"
+	}
+	return "installer"
+}
+
+// Gives each item the game's variables plus its own.
+func setItemVars(downloads []*Download, gameVars map[string]string) {
+	for _, d := range downloads {
+		vars := map[string]string{}
+		for name, value := range gameVars {
+			vars[name] = value
+		}
+		vars["platform"] = d.Platform
+		vars["language"] = d.Language
+		vars["version"] = d.Version
+		if d.Version == "<no ver>" {
+			vars["version"] = ""
+		}
+		vars["date"] = d.Date
+		vars["type"] = getItemType(d)
+		vars["name"] = d.Name
+		d.Vars = vars
+	}
+}
+
+func getExt(fname string) string {
+	lower := strings.ToLower(fname)
+	for _, ext := range multiExts {
+		if strings.HasSuffix(lower, ext) {
+			return fname[len(fname)-len(ext):]
+		}
+	}
+	return filepath.Ext(fname)
+}
+
+// Names a downloaded file with the file template. The template gives the
+// name without the extension, the original one is always kept. GOG's own
+// names get sanitised too.
+func getItemFname(cfg *Config, item *Download, fname string) string {
+	if cfg.FileTmpl == nil {
+		return sanitiseFname(fname)
+	}
+	ext := getExt(fname)
+	vars := map[string]string{}
+	for name, value := range item.Vars {
+		vars[name] = value
+	}
+	vars["fname"] = strings.TrimSuffix(fname, ext)
+	vars["ext"] = ext
+	name, err := renderTemplate(cfg.FileTmpl, vars)
+	if err != nil {
+		handleErr("failed to render file template, keeping "+fname, err, false)
+		return sanitiseFname(fname)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return sanitiseFname(fname)
+	}
+	return sanitiseFname(name + ext)
+}
+
+// Without {{.fname}} a file template can give the parts of a multi-part
+// installer the same name. All but one would be skipped as already existing,
+// so the game's refused instead.
+func checkFnames(cfg *Config, downloads []*Download) error {
+	if cfg.FileTmpl == nil {
+		return nil
+	}
+	mainPlatform := getMainPlatform(downloads)
+	multiLang := isMultiLang(downloads)
+	multiPlatform := isMultiPlatform(downloads) && !cfg.SplitPlatforms
+	seen := map[string]*Download{}
+	for _, item := range downloads {
+		fname, _, err := getFname(item.ManualURL)
+		if err != nil {
+			return err
+		}
+		fname = getItemFname(cfg, item, fname)
+		key := filepath.Join(
+			getPathPlatform(cfg, item, mainPlatform),
+			getItemDir("", item, multiLang, multiPlatform), fname)
+		other, ok := seen[key]
+		if ok {
+			return fmt.Errorf(
+				"file template names both %s and %s %s, add {{.fname}} to it",
+				other.Name, item.Name, fname)
+		}
+		seen[key] = item
+	}
+	return nil
+}
+
+func previewTemplates(cfg *Config) error {
+	gameVars, downloads := getSampleGame()
+	folder, err := renderTemplate(cfg.FolderTmpl, gameVars)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Sample game: " + gameVars["title"])
+	fmt.Println("Folder: " + sanitise(folder))
+	if cfg.FileTmpl == nil {
+		fmt.Println("Files keep GOG's names, set a file template to rename them.")
+		return nil
+	}
+	fmt.Println("Files:")
+	for _, d := range downloads {
+		fmt.Println("  " + d.Fname + " -> " + getItemFname(cfg, d, d.Fname))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompileTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		line int
+		col  int
+		msg  string
+	}{
+		{"parse error", "{{.title} [GOG]", 1, 0, "bad character"},
+		{"unknown variable", "{{.titel}} [GOG]", 1, 2, `no entry for key "titel"`},
+		{"multi-line unknown variable", "{{.title}} [GOG]\n{{.year}} {{.titel}}", 2, 12, `no entry for key "titel"`},
+		{"multi-line parse error", "{{.title}}\n{{if .year}}x{{.slug}", 2, 13, "bad character"},
+		{"undefined function", "a {{.title}} {{bad}}", 1, 13, `function "bad" not defined`},
+	}
+	for _, tt := range tests {
+		_, err := compileTemplate("folderTemplate", tt.text)
+		var tmplErr *TemplateError
+		if !errors.As(err, &tmplErr) {
+			t.Errorf("%s: got %v, want a *TemplateError", tt.name, err)
+			continue
+		}
+		if tmplErr.Line != tt.line || tmplErr.Col != tt.col {
+			t.Errorf("%s: got line %d, col %d, want line %d, col %d",
+				tt.name, tmplErr.Line, tmplErr.Col, tt.line, tt.col)
+		}
+		if !strings.Contains(tmplErr.Msg, tt.msg) {
+			t.Errorf("%s: msg %q doesn't contain %q", tt.name, tmplErr.Msg, tt.msg)
+		}
+
+		// Caret under the offending action on the quoted line.
+		lines := strings.Split(tmplErr.Error(), "\n")
+		if len(lines) != 3 {
+			t.Errorf("%s: Error() = %q, want 3 lines", tt.name, tmplErr.Error())
+			continue
+		}
+		srcLine := strings.Split(tt.text, "\n")[tt.line-1]
+		if lines[1] != "    "+srcLine {
+			t.Errorf("%s: quoted line %q, want %q", tt.name, lines[1], srcLine)
+		}
+		if lines[2] != "    "+strings.Repeat(" ", tt.col)+"^" {
+			t.Errorf("%s: caret line %q, want caret at col %d", tt.name, lines[2], tt.col)
+		}
+	}
+}
+
+func TestFindBadAction(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"{{.title}} [GOG]", -1},
+		{"{{.title} [GOG]", 0},
+		{"{{.title}} {{.slug", 11},
+		{"{{.title}}\n{{.year}} {{.a b.}}", 21},
+		// Keywords only parse inside their block, so they're skipped.
+		{"{{if .year}}{{.year}}{{end}} {{)}}", 29},
+	}
+	for _, tt := range tests {
+		got := findBadAction(tt.text)
+		if got != tt.want {
+			t.Errorf("findBadAction(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateErrorNoCol(t *testing.T) {
+	err := &TemplateError{Name: "fileTemplate", Text: "x", Line: 1, Col: -1, Msg: "oops"}
+	want := "fileTemplate: line 1: oops"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestCheckFnames(t *testing.T) {
+	files := map[string][]byte{}
+	for _, fname := range []string{"setup.exe", "setup-1.bin", "setup-2.bin"} {
+		files[fname] = []byte("data")
+	}
+	newGameServer(t, "{}", files)
+	getDownloads := func(fnames ...string) []*Download {
+		var downloads []*Download
+		for i, fname := range fnames {
+			downloads = append(downloads, &Download{
+				ManualURL: siteUrl + "/downloads/" + fname,
+				Name:      fmt.Sprintf("Part %d", i+1),
+				Version:   "1.0",
+				Language:  "English",
+				Platform:  "windows",
+			})
+		}
+		setItemVars(downloads, map[string]string{"slug": "game"})
+		return downloads
+	}
+
+	tests := []struct {
+		fileTemplate string
+		fnames       []string
+		wantErr      bool
+	}{
+		{"", []string{"setup.exe", "setup-1.bin", "setup-2.bin"}, false},
+		{"{{.slug}}_{{.fname}}", []string{"setup.exe", "setup-1.bin", "setup-2.bin"}, false},
+		// Extensions differ, so these don't clash.
+		{"{{.slug}}_{{.version}}", []string{"setup.exe", "setup-1.bin"}, false},
+		{"{{.slug}}_{{.version}}", []string{"setup.exe", "setup-1.bin", "setup-2.bin"}, true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{}
+		if tt.fileTemplate != "" {
+			tmpl, err := compileTemplate("fileTemplate", tt.fileTemplate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.FileTmpl = tmpl
+		}
+		err := checkFnames(cfg, getDownloads(tt.fnames...))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q with %v: got %v, want err %v", tt.fileTemplate, tt.fnames, err, tt.wantErr)
+		}
+	}
+}
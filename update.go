@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFname  = ".gog-dl-state.json"
+	archiveName = "archive"
+)
+
+func readGameState(gamePath string, meta *GameMeta, id int) (*GameState, error) {
+	data, err := os.ReadFile(filepath.Join(gamePath, stateFname))
+	if err != nil {
+		if os.IsNotExist(err) {
+			state := &GameState{
+				ID:    id,
+				Title: meta.Title,
+				Items: map[string]*ItemState{},
+			}
+			return state, nil
+		}
+		return nil, err
+	}
+	var obj GameState
+	err = json.Unmarshal(data, &obj)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Items == nil {
+		obj.Items = map[string]*ItemState{}
+	}
+	return &obj, nil
+}
+
+func writeGameState(gamePath string, state *GameState) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	statePath := filepath.Join(gamePath, stateFname)
+	err = os.WriteFile(statePath+".tmp", data, 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(statePath+".tmp", statePath)
+}
+
+// Items are keyed by their manual URL's path as it stays the same between
+// versions and doesn't depend on the host used to sign in.
+func itemKey(manualUrl string) string {
+	u, err := url.Parse(manualUrl)
+	if err != nil {
+		return manualUrl
+	}
+	return u.Path
+}
+
+func recordItem(state *GameState, item *Download, dir string) {
+	state.Items[itemKey(item.ManualURL)] = &ItemState{
+		Name:     item.Name,
+		Version:  item.Version,
+		Date:     item.Date,
+		Size:     item.Size,
+		Fname:    item.Fname,
+		Dir:      dir,
+		Language: item.Language,
+		Platform: item.Platform,
+	}
+}
+
+func hasChanged(old *ItemState, item *Download) bool {
+	return old.Version != item.Version || old.Date != item.Date || old.Size != item.Size
+}
+
+// Archived items keep their subfolder, so same named items from different
+// language or platform folders don't overwrite each other.
+func retireItem(gamePath, dir, fpath string, archive bool) error {
+	exists, _, err := fileExists(fpath)
+	if err != nil || !exists {
+		return err
+	}
+	if !archive {
+		return os.Remove(fpath)
+	}
+	archivePath := filepath.Join(gamePath, archiveName, dir)
+	err = makeDirs(archivePath)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fpath, filepath.Join(archivePath, filepath.Base(fpath)))
+}
+
+// The old item's only retired once the new one's downloaded and verified,
+// so a failed update leaves it in place. New items have no old file.
+func updateItem(cfg *Config, gamePath string, old *ItemState, item *Download) error {
+	dir := filepath.Join(gamePath, old.Dir)
+	err := makeDirs(dir)
+	if err != nil {
+		return err
+	}
+	incompPath, fpath, err := fetchItem(cfg, item, dir, true)
+	if err != nil {
+		return err
+	}
+	if old.Fname != "" {
+		err = retireItem(gamePath, old.Dir, filepath.Join(dir, old.Fname), cfg.Archive)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(incompPath, fpath)
+}
+
+// Items missing from the state file are usually parts or patches a new build
+// added. Goodies and DLCs are only picked up when they're turned on, as they
+// may have been left out on purpose.
+func wantNewItem(cfg *Config, item *Download) bool {
+	switch getItemType(item) {
+	case "goodie":
+		return cfg.Goodies
+	case "dlc":
+		return cfg.Dlc
+	}
+	return true
+}
+
+// checkFnames for an item added to an already downloaded game, against the
+// recorded items GOG still lists.
+func checkNewFname(cfg *Config, state *GameState, listed map[string]bool, dir string, item *Download) error {
+	if cfg.FileTmpl == nil {
+		return nil
+	}
+	fname, _, err := getFname(item.ManualURL)
+	if err != nil {
+		return err
+	}
+	fname = getItemFname(cfg, item, fname)
+	for key, old := range state.Items {
+		if listed[key] && old.Dir == dir && old.Fname == fname {
+			return fmt.Errorf(
+				"file template names both %s and %s %s, add {{.fname}} to it",
+				old.Name, item.Name, fname)
+		}
+	}
+	return nil
+}
+
+// Recorded items outside of this run's platforms and languages aren't
+// listed, but weren't removed either.
+func isSelected(cfg *Config, old *ItemState) bool {
+	if old.Platform != "" && !contains(cfg.Platforms, old.Platform) {
+		return false
+	}
+	return old.Language == "" || wantLang(old.Language, cfg.Languages)
+}
+
+func getPathPlatforms(cfg *Config) []string {
+	if !cfg.SplitPlatforms {
+		return []string{""}
+	}
+	return cfg.Platforms
+}
+
+func hasGameState(cfg *Config, product *Product, meta *GameMeta) (bool, error) {
+	for _, platform := range getPathPlatforms(cfg) {
+		gamePath := getGamePath(cfg, product, meta, platform)
+		exists, _, err := fileExists(filepath.Join(gamePath, stateFname))
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// Only games downloaded with a state file get checked. Items GOG added since
+// are downloaded, ones it no longer lists are retired.
+func updateGame(cfg *Config, product *Product, summary *Summary) error {
+	id := product.ID
+	gameMeta, err := getGameMeta(id)
+	if err != nil {
+		return errors.New("failed to get game meta\n" + err.Error())
+	}
+	exists, err := hasGameState(cfg, product, gameMeta)
+	if err != nil || !exists {
+		return err
+	}
+
+	downloads, err := parseDownloads(gameMeta, cfg.Platforms, cfg.Languages, true, true)
+	if err != nil {
+		return errors.New("failed to parse items\n" + err.Error())
+	}
+	setItemVars(downloads, parseTempMeta(product, gameMeta, ""))
+	fmt.Println("--" + gameMeta.Title + "--")
+
+	mainPlatform := getMainPlatform(downloads)
+	multiLang := isMultiLang(downloads)
+	multiPlatform := isMultiPlatform(downloads) && !cfg.SplitPlatforms
+	gamePaths := map[string]string{}
+	states := map[string]*GameState{}
+	listed := map[string]bool{}
+	for _, item := range downloads {
+		listed[itemKey(item.ManualURL)] = true
+	}
+	for _, item := range downloads {
+		platform := getPathPlatform(cfg, item, mainPlatform)
+		gamePath, ok := gamePaths[platform]
+		if !ok {
+			gamePath = getGamePath(cfg, product, gameMeta, platform)
+			gamePaths[platform] = gamePath
+			// Other platforms' folders may not have been downloaded.
+			exists, _, err := fileExists(filepath.Join(gamePath, stateFname))
+			if err != nil {
+				return errors.New("failed to read game state\n" + err.Error())
+			}
+			if exists {
+				state, err := readGameState(gamePath, gameMeta, id)
+				if err != nil {
+					return errors.New("failed to read game state\n" + err.Error())
+				}
+				states[platform] = state
+			}
+		}
+		state, ok := states[platform]
+		if !ok {
+			continue
+		}
+
+		old, ok := state.Items[itemKey(item.ManualURL)]
+		if !ok {
+			if !wantNewItem(cfg, item) {
+				continue
+			}
+			itemPath := getItemDir(gamePath, item, multiLang, multiPlatform)
+			old = &ItemState{Dir: getRelDir(gamePath, itemPath)}
+			fmt.Printf("%s: new (%s)\n", item.Name, item.Version)
+			err = checkNewFname(cfg, state, listed, old.Dir, item)
+			if err != nil {
+				handleErr("failed to name item", err, false)
+				summary.Failed = append(summary.Failed, gameMeta.Title+" - "+item.Name)
+				continue
+			}
+		} else if !hasChanged(old, item) {
+			continue
+		} else {
+			fmt.Printf("%s: %s -> %s\n", item.Name, old.Version, item.Version)
+		}
+		if cfg.DryRun {
+			summary.Updated++
+			continue
+		}
+		err = updateItem(cfg, gamePath, old, item)
+		if err != nil {
+			handleErr("failed to update item", err, false)
+			summary.Failed = append(summary.Failed, gameMeta.Title+" - "+item.Name)
+			continue
+		}
+		summary.Updated++
+		recordItem(state, item, old.Dir)
+		err = writeGameState(gamePath, state)
+		if err != nil {
+			handleErr("failed to write game state", err, false)
+		}
+	}
+
+	for platform, state := range states {
+		gamePath := gamePaths[platform]
+		for key, old := range state.Items {
+			if listed[key] || !isSelected(cfg, old) {
+				continue
+			}
+			fmt.Printf("%s: no longer listed\n", old.Name)
+			if cfg.DryRun {
+				summary.Removed++
+				continue
+			}
+			if old.Fname != "" {
+				fpath := filepath.Join(gamePath, old.Dir, old.Fname)
+				err = retireItem(gamePath, old.Dir, fpath, cfg.Archive)
+				if err != nil {
+					handleErr("failed to retire item", err, false)
+					summary.Failed = append(summary.Failed, gameMeta.Title+" - "+old.Name)
+					continue
+				}
+			}
+			summary.Removed++
+			delete(state.Items, key)
+			err = writeGameState(gamePath, state)
+			if err != nil {
+				handleErr("failed to write game state", err, false)
+			}
+		}
+	}
+	return nil
+}
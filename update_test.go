@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fake GOG site: the game's details plus its files, with no checksums.
+func newGameServer(t *testing.T, meta string, files map[string][]byte) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/account/gameDetails/1.json":
+			w.Write([]byte(meta))
+		case strings.HasPrefix(r.URL.Path, "/downlink/"):
+			json.NewEncoder(w).Encode(&Downlink{})
+		case strings.HasPrefix(r.URL.Path, "/downloads/"):
+			name := filepath.Base(r.URL.Path)
+			data, ok := files[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	oldSite := siteUrl
+	siteUrl = srv.URL
+	t.Cleanup(func() {
+		siteUrl = oldSite
+		srv.Close()
+	})
+}
+
+func TestUpdateGameAddsAndRetiresItems(t *testing.T) {
+	// Build 2 split the installer, adding a part, and dropped the old patch.
+	meta := `{"title": "Test Game", "downloads": [["English", {"windows": [
+		{"manualUrl": "/downloads/setup.exe", "name": "Test Game", "version": "2", "size": "4 B"},
+		{"manualUrl": "/downloads/setup-1.bin", "name": "Test Game (part 2)", "version": "2", "size": "4 B"}
+	]}]]}`
+	newGameServer(t, meta, map[string][]byte{
+		"setup.exe":   []byte("new!"),
+		"setup-1.bin": []byte("part"),
+	})
+
+	tmpl, err := compileTemplate("folderTemplate", defTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		OutPath:     t.TempDir(),
+		Platforms:   []string{"windows"},
+		Languages:   []string{"en"},
+		Connections: 1,
+		FolderTmpl:  tmpl,
+	}
+	gamePath := filepath.Join(cfg.OutPath, "Test Game [GOG]")
+	err = makeDirs(gamePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range []string{"setup.exe", "patch.exe"} {
+		err = os.WriteFile(filepath.Join(gamePath, fname), []byte("old"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := &GameState{ID: 1, Title: "Test Game", Items: map[string]*ItemState{
+		"/downloads/setup.exe": {
+			Name: "Test Game", Version: "1", Size: "3 B", Fname: "setup.exe",
+			Language: "English", Platform: "windows",
+		},
+		"/downloads/patch.exe": {
+			Name: "Test Game Patch", Version: "1", Size: "3 B", Fname: "patch.exe",
+			Language: "English", Platform: "windows",
+		},
+		// Another language isn't part of this run, so it's left alone.
+		"/downloads/setup_de.exe": {
+			Name: "Test Game", Version: "1", Size: "3 B", Fname: "setup_de.exe",
+			Language: "Deutsch", Platform: "windows",
+		},
+	}}
+	err = writeGameState(gamePath, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := &Summary{}
+	err = updateGame(cfg, &Product{ID: 1, Title: "Test Game"}, summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Updated != 2 || summary.Removed != 1 || len(summary.Failed) != 0 {
+		t.Errorf("updated %d, removed %d, failed %v, want 2, 1, none",
+			summary.Updated, summary.Removed, summary.Failed)
+	}
+
+	for fname, want := range map[string]string{"setup.exe": "new!", "setup-1.bin": "part"} {
+		data, err := os.ReadFile(filepath.Join(gamePath, fname))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", fname, data, err, want)
+		}
+	}
+	exists, _, _ := fileExists(filepath.Join(gamePath, "patch.exe"))
+	if exists {
+		t.Error("patch.exe is no longer listed but wasn't retired")
+	}
+
+	state, err = readGameState(gamePath, &GameMeta{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"/downloads/setup.exe", "/downloads/setup-1.bin", "/downloads/setup_de.exe"} {
+		if state.Items[key] == nil {
+			t.Errorf("%s missing from the state file", key)
+		}
+	}
+	if state.Items["/downloads/patch.exe"] != nil {
+		t.Error("retired patch.exe is still in the state file")
+	}
+	if state.Items["/downloads/setup.exe"].Version != "2" {
+		t.Errorf("setup.exe recorded as version %s, want 2", state.Items["/downloads/setup.exe"].Version)
+	}
+}
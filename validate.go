@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Maximum edit distance for a key to be suggested.
+const maxSuggestDist = 3
+
+var settingsKeys = map[string]string{
+	"platform":       "string",
+	"language":       "string",
+	"folderTemplate": "string",
+	"fileTemplate":   "string",
+	"sanitise":       "string",
+	"goodies":        "bool",
+	"dlc":            "bool",
+	"outPath":        "string",
+	"cookiesPath":    "string",
+	"sessionPath":    "string",
+	"tokenPath":      "string",
+	"batch":          "bool",
+	"updatedOnly":    "bool",
+	"connections":    "int",
+	"workers":        "int",
+}
+
+func (e *ConfigError) Error() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("%s has %d problem(s):", e.Path, len(e.Problems)))
+	for _, problem := range e.Problems {
+		lines = append(lines, "  - "+problem)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func editDist(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func suggestKey(key string, known map[string]string) string {
+	best := ""
+	bestDist := maxSuggestDist + 1
+	for candidate := range known {
+		dist := editDist(key, candidate)
+		if dist < bestDist {
+			best, bestDist = candidate, dist
+		}
+	}
+	return best
+}
+
+func checkString(field, key, value string) []string {
+	var problems []string
+	switch key {
+	case "platform":
+		_, _, err := parsePlatforms(value)
+		if value != "" && err != nil {
+			problems = append(problems, field+": "+err.Error()+
+				", expected windows/win, linux, mac/osx or all")
+		}
+	case "language":
+		_, err := parseLangs(value)
+		if value != "" && err != nil {
+			problems = append(problems, field+": "+err.Error()+
+				", expected "+strings.Join(languages, ", "))
+		}
+	case "sanitise":
+		if value != "" && !isSanitisePolicy(value) {
+			problems = append(problems, field+": invalid policy: "+value+
+				", expected "+strings.Join(sanitisePolicies, ", "))
+		}
+	case "folderTemplate", "fileTemplate":
+		_, err := compileTemplate(field, value)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+func checkInt(field string, value int) []string {
+	if value < 0 {
+		return []string{field + ": can't be negative"}
+	}
+	return nil
+}
+
+func checkValue(prefix, key string, raw json.RawMessage) []string {
+	field := prefix + key
+	switch settingsKeys[key] {
+	case "string":
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			return []string{field + ": must be a string, got " + string(raw)}
+		}
+		return checkString(field, key, value)
+	case "bool":
+		var value bool
+		if json.Unmarshal(raw, &value) != nil {
+			return []string{field + ": must be true or false, got " + string(raw)}
+		}
+	case "int":
+		var value int
+		if json.Unmarshal(raw, &value) != nil {
+			return []string{field + ": must be a whole number, got " + string(raw)}
+		}
+		return checkInt(field, value)
+	}
+	return nil
+}
+
+func checkKeys(prefix string, obj map[string]json.RawMessage, allowProfiles bool) []string {
+	var (
+		problems []string
+		keys     []string
+	)
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		raw := obj[key]
+		if key == "profiles" && allowProfiles {
+			problems = append(problems, checkProfiles(raw)...)
+			continue
+		}
+		if _, ok := settingsKeys[key]; !ok {
+			problem := prefix + key + ": unknown field"
+			known := settingsKeys
+			if allowProfiles {
+				known = map[string]string{"profiles": "object"}
+				for k, v := range settingsKeys {
+					known[k] = v
+				}
+			}
+			suggestion := suggestKey(key, known)
+			if suggestion != "" {
+				problem += ", did you mean " + suggestion + "?"
+			}
+			problems = append(problems, problem)
+			continue
+		}
+		problems = append(problems, checkValue(prefix, key, raw)...)
+	}
+	return problems
+}
+
+func checkProfiles(raw json.RawMessage) []string {
+	var (
+		problems []string
+		names    []string
+		profiles map[string]map[string]json.RawMessage
+	)
+	err := json.Unmarshal(raw, &profiles)
+	if err != nil {
+		return []string{"profiles: must be an object of profile objects"}
+	}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems,
+			checkKeys("profiles."+name+".", profiles[name], false)...)
+	}
+	return problems
+}
+
+// Checks every field up front so all problems get reported in one go,
+// json.Unmarshal would just skip unknown keys and stop at the first bad type.
+func validateConfig(cfgPath string, data []byte) error {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(data, &obj)
+	if err != nil {
+		return &ConfigError{Path: cfgPath, Problems: []string{err.Error()}}
+	}
+	problems := checkKeys("", obj, true)
+	if len(problems) > 0 {
+		path := cfgPath
+		if path == "" {
+			path = "Environment"
+		}
+		return &ConfigError{Path: path, Problems: problems}
+	}
+	return nil
+}
+
+// Runs on its own for config check, so it reports env var problems too
+// instead of stopping at the first one. Without a config file only the env
+// vars are checked.
+func checkConfig(cfgPath string) error {
+	var problems []string
+	if cfgPath != "" {
+		data, err := os.ReadFile(cfgPath)
+		if err != nil {
+			return err
+		}
+		err = validateConfig(cfgPath, data)
+		if err != nil {
+			cfgErr, ok := err.(*ConfigError)
+			if !ok {
+				return err
+			}
+			problems = append(problems, cfgErr.Problems...)
+		}
+	}
+	envSettings, err := getEnvSettings()
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		// Same checks as the file's fields, unset vars are left out.
+		envStrings := []struct{ name, key, value string }{
+			{"PLATFORM", "platform", envSettings.Platform},
+			{"LANGUAGE", "language", envSettings.Language},
+			{"FOLDER_TEMPLATE", "folderTemplate", envSettings.FolderTemplate},
+			{"FILE_TEMPLATE", "fileTemplate", envSettings.FileTemplate},
+			{"SANITISE", "sanitise", envSettings.Sanitise},
+		}
+		for _, env := range envStrings {
+			if env.value != "" {
+				problems = append(problems, checkString(envPrefix+env.name, env.key, env.value)...)
+			}
+		}
+		problems = append(problems, checkInt(envPrefix+"CONNECTIONS", envSettings.Connections)...)
+		problems = append(problems, checkInt(envPrefix+"WORKERS", envSettings.Workers)...)
+	}
+	if len(problems) > 0 {
+		path := cfgPath
+		if path == "" {
+			path = "Environment"
+		}
+		return &ConfigError{Path: path, Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"valid", `{"platform": "linux", "connections": 4, "profiles": {"mac": {"platform": "mac"}}}`, nil},
+		{"unknown key", `{"platfrom": "linux"}`, []string{"platfrom: unknown field, did you mean platform?"}},
+		{"unknown key no suggestion", `{"zzzzzzzzzz": 1}`, []string{"zzzzzzzzzz: unknown field"}},
+		{"unknown profile key", `{"profiles": {"mac": {"workres": 2}}}`,
+			[]string{"profiles.mac.workres: unknown field, did you mean workers?"}},
+		{"several problems", `{"goodies": "yes", "connections": -1, "sanitise": "nope", "langauge": "en"}`,
+			[]string{
+				"connections: can't be negative",
+				"goodies: must be true or false",
+				"langauge: unknown field, did you mean language?",
+				"sanitise: invalid policy: nope",
+			}},
+		{"bad template", `{"fileTemplate": "{{.titel}}"}`, []string{`fileTemplate: line 1, col 3: map has no entry for key "titel"`}},
+	}
+	for _, tt := range tests {
+		err := validateConfig("config.json", []byte(tt.data))
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: got %v, want no error", tt.name, err)
+			}
+			continue
+		}
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Errorf("%s: got %v, want a *ConfigError", tt.name, err)
+			continue
+		}
+		if len(cfgErr.Problems) != len(tt.want) {
+			t.Errorf("%s: got %q, want %d problem(s)", tt.name, cfgErr.Problems, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.HasPrefix(cfgErr.Problems[i], want) {
+				t.Errorf("%s: problem %d is %q, want %q", tt.name, i, cfgErr.Problems[i], want)
+			}
+		}
+	}
+}
+
+func TestCheckConfigEnv(t *testing.T) {
+	t.Setenv(envPrefix+"FOLDER_TEMPLATE", "{{.titel}}")
+	t.Setenv(envPrefix+"FILE_TEMPLATE", "{{.fname}")
+	t.Setenv(envPrefix+"CONNECTIONS", "-2")
+	t.Setenv(envPrefix+"WORKERS", "-1")
+	t.Setenv(envPrefix+"PLATFORM", "linux")
+
+	err := checkConfig("")
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("got %v, want a *ConfigError", err)
+	}
+	want := []string{
+		envPrefix + "FOLDER_TEMPLATE: line 1, col 3:",
+		envPrefix + "FILE_TEMPLATE: line 1, col 1:",
+		envPrefix + "CONNECTIONS: can't be negative",
+		envPrefix + "WORKERS: can't be negative",
+	}
+	if len(cfgErr.Problems) != len(want) {
+		t.Fatalf("got %q, want %d problems", cfgErr.Problems, len(want))
+	}
+	for i := range want {
+		if !strings.HasPrefix(cfgErr.Problems[i], want[i]) {
+			t.Errorf("problem %d is %q, want %q", i, cfgErr.Problems[i], want[i])
+		}
+	}
+}
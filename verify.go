@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (r *VerifyReport) isClean() bool {
+	return len(r.Missing) == 0 && len(r.WrongSize) == 0 &&
+		len(r.Corrupt) == 0 && len(r.Failed) == 0
+}
+
+func isPartialFile(fname string) bool {
+	for _, ext := range []string{".incomplete", ".state", ".tmp"} {
+		if strings.HasSuffix(fname, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Anything left in a game's folders that GOG no longer lists, usually the
+// previous version of an installer.
+func findStale(dirs map[string]bool, expected map[string]bool) ([]string, error) {
+	var stale []string
+	for dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			fpath := filepath.Join(dir, entry.Name())
+			if entry.IsDir() || isPartialFile(entry.Name()) ||
+				entry.Name() == stateFname || expected[fpath] {
+				continue
+			}
+			stale = append(stale, fpath)
+		}
+	}
+	return stale, nil
+}
+
+// The item's folder may have gone along with it.
+func redownloadItem(cfg *Config, item *Download, fpath string) error {
+	err := os.Remove(fpath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = makeDirs(filepath.Dir(fpath))
+	if err != nil {
+		return err
+	}
+	return downloadItem(cfg, item, filepath.Dir(fpath))
+}
+
+// Repaired items are the version GOG has now, so update mustn't see the
+// old one.
+func recordRepair(states map[string]*GameState, gamePath string, meta *GameMeta, id int, item *Download, dir string) error {
+	state, ok := states[gamePath]
+	if !ok {
+		var err error
+		state, err = readGameState(gamePath, meta, id)
+		if err != nil {
+			return err
+		}
+		states[gamePath] = state
+	}
+	recordItem(state, item, getRelDir(gamePath, dir))
+	return writeGameState(gamePath, state)
+}
+
+// Returns the problem with the file, or an empty string if it's fine.
+func verifyFile(cfg *Config, item *Download, fpath string, remoteSize int64) (string, error) {
+	exists, size, err := fileExists(fpath)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if cfg.Repair {
+			return "missing", redownloadItem(cfg, item, fpath)
+		}
+		return "missing", nil
+	}
+
+	sum, err := getChecksum(item.ManualURL)
+	if err != nil {
+		handleErr("failed to get checksum for "+item.Name+", checking size only", err, false)
+	}
+	if remoteSize > 0 && size != remoteSize {
+		if !cfg.Repair {
+			return "wrong size", nil
+		}
+		if sum == nil {
+			return "wrong size", redownloadItem(cfg, item, fpath)
+		}
+		return "wrong size", verifyItem(item.ManualURL, item.Name, fpath)
+	}
+	if sum == nil {
+		return "", nil
+	}
+
+	ok, _, err := checkFile(fpath, sum)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return "", nil
+	}
+	if cfg.Repair {
+		return "corrupt", verifyItem(item.ManualURL, item.Name, fpath)
+	}
+	return "corrupt", nil
+}
+
+func verifyGame(cfg *Config, product *Product, report *VerifyReport) error {
+	gameMeta, err := getGameMeta(product.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Println("--" + gameMeta.Title + "--")
+
+	downloads, err := parseDownloads(
+		gameMeta, cfg.Platforms, cfg.Languages, cfg.Goodies, cfg.Dlc)
+	if err != nil {
+		return err
+	}
+	setItemVars(downloads, parseTempMeta(product, gameMeta, ""))
+
+	mainPlatform := getMainPlatform(downloads)
+	gamePaths := map[string]string{}
+	multiLang := isMultiLang(downloads)
+	multiPlatform := isMultiPlatform(downloads) && !cfg.SplitPlatforms
+	dirs := map[string]bool{}
+	expected := map[string]bool{}
+	states := map[string]*GameState{}
+	for _, item := range downloads {
+		fname, remoteSize, err := getFname(item.ManualURL)
+		if err != nil {
+			handleErr("failed to get filename of "+item.Name, err, false)
+			report.Failed = append(report.Failed, gameMeta.Title+" - "+item.Name)
+			continue
+		}
+		fname = getItemFname(cfg, item, fname)
+		platform := getPathPlatform(cfg, item, mainPlatform)
+		gamePath, ok := gamePaths[platform]
+		if !ok {
+			gamePath = getGamePath(cfg, product, gameMeta, platform)
+			gamePaths[platform] = gamePath
+			report.Checked[gamePath] = true
+		}
+		dir := getItemDir(gamePath, item, multiLang, multiPlatform)
+		fpath := filepath.Join(dir, fname)
+		dirs[dir] = true
+		expected[fpath] = true
+
+		problem, err := verifyFile(cfg, item, fpath, remoteSize)
+		if err != nil {
+			handleErr("failed to verify "+fpath, err, false)
+			report.Failed = append(report.Failed, fpath)
+			continue
+		}
+		if problem == "" {
+			report.OK++
+			continue
+		}
+		if cfg.Repair {
+			fmt.Println("Repaired, was " + problem + ": " + fpath)
+			report.Repaired = append(report.Repaired, fpath)
+			item.Fname = fname
+			err = recordRepair(states, gamePath, gameMeta, product.ID, item, dir)
+			if err != nil {
+				handleErr("failed to write game state", err, false)
+			}
+			continue
+		}
+		fmt.Println("Item " + problem + ": " + fpath)
+		switch problem {
+		case "missing":
+			report.Missing = append(report.Missing, fpath)
+		case "wrong size":
+			report.WrongSize = append(report.WrongSize, fpath)
+		case "corrupt":
+			report.Corrupt = append(report.Corrupt, fpath)
+		}
+	}
+
+	stale, err := findStale(dirs, expected)
+	if err != nil {
+		return err
+	}
+	for _, fpath := range stale {
+		fmt.Println("Item stale: " + fpath)
+	}
+	report.Stale = append(report.Stale, stale...)
+	return nil
+}
+
+// Names a game folder by its state file, falling back to the library index.
+func getFolderTitle(outPath, folder string, library *Library) string {
+	data, err := os.ReadFile(filepath.Join(outPath, folder, stateFname))
+	if err == nil {
+		var state GameState
+		if json.Unmarshal(data, &state) == nil && state.Title != "" {
+			return state.Title
+		}
+	}
+	for _, game := range library.Games {
+		if game.Folder == folder {
+			return game.Title
+		}
+	}
+	return ""
+}
+
+// Walks the output folder for game folders the search didn't check, like
+// games no longer owned or left out by the query, platform or language.
+func findUnchecked(cfg *Config, checked map[string]bool) ([]string, error) {
+	entries, err := os.ReadDir(cfg.OutPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	library, err := readLibrary(cfg.OutPath)
+	if err != nil {
+		return nil, err
+	}
+	var unchecked []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		gamePath := filepath.Join(cfg.OutPath, entry.Name())
+		if checked[gamePath] {
+			continue
+		}
+		title := getFolderTitle(cfg.OutPath, entry.Name(), library)
+		if title == "" {
+			title = "unknown game"
+		}
+		unchecked = append(unchecked, gamePath+" ("+title+")")
+	}
+	return unchecked, nil
+}
+
+func verifyLibrary(cfg *Config, products []Product) *VerifyReport {
+	report := &VerifyReport{Checked: map[string]bool{}}
+	for _, p := range products {
+		err := verifyGame(cfg, &p, report)
+		if err != nil {
+			handleErr("failed to verify "+p.Title, err, false)
+			report.Failed = append(report.Failed, p.Title)
+		}
+	}
+	unchecked, err := findUnchecked(cfg, report.Checked)
+	if err != nil {
+		handleErr("failed to walk output folder", err, false)
+	}
+	for _, folder := range unchecked {
+		fmt.Println("Not in search, not checked: " + folder)
+	}
+	report.Unchecked = unchecked
+	return report
+}
+
+func printVerifyReport(report *VerifyReport) {
+	fmt.Printf(
+		"\nOK: %d, repaired: %d, missing: %d, wrong size: %d, corrupt: %d, stale: %d, failed: %d, not checked: %d.\n",
+		report.OK, len(report.Repaired), len(report.Missing), len(report.WrongSize),
+		len(report.Corrupt), len(report.Stale), len(report.Failed), len(report.Unchecked))
+	for _, failed := range report.Failed {
+		fmt.Println("  " + failed)
+	}
+}
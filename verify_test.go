@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyRepairMissingFolder(t *testing.T) {
+	meta := `{"title": "Test Game", "downloads": [
+		["English", {"windows": [{"manualUrl": "/downloads/setup_en.exe", "name": "Test Game", "version": "2"}]}],
+		["Deutsch", {"windows": [{"manualUrl": "/downloads/setup_de.exe", "name": "Test Game", "version": "2"}]}]
+	]}`
+	newGameServer(t, meta, map[string][]byte{
+		"setup_en.exe": []byte("english"),
+		"setup_de.exe": []byte("deutsch"),
+	})
+
+	tmpl, err := compileTemplate("folderTemplate", defTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		OutPath:     t.TempDir(),
+		Platforms:   []string{"windows"},
+		Languages:   []string{"en", "de"},
+		Connections: 1,
+		FolderTmpl:  tmpl,
+		Repair:      true,
+	}
+	// The English folder's there, the German one was deleted.
+	gamePath := filepath.Join(cfg.OutPath, "Test Game [GOG]")
+	err = makeDirs(filepath.Join(gamePath, "English"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(gamePath, "English", "setup_en.exe"), []byte("english"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &GameState{ID: 1, Title: "Test Game", Items: map[string]*ItemState{
+		"/downloads/setup_de.exe": {
+			Name: "Test Game", Version: "1", Fname: "setup_de.exe", Dir: "Deutsch",
+			Language: "Deutsch", Platform: "windows",
+		},
+	}}
+	err = writeGameState(gamePath, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := &VerifyReport{Checked: map[string]bool{}}
+	err = verifyGame(cfg, &Product{ID: 1, Title: "Test Game"}, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK != 1 || len(report.Repaired) != 1 || len(report.Failed) != 0 {
+		t.Fatalf("ok %d, repaired %v, failed %v, want 1 ok and 1 repaired",
+			report.OK, report.Repaired, report.Failed)
+	}
+	data, err := os.ReadFile(filepath.Join(gamePath, "Deutsch", "setup_de.exe"))
+	if err != nil || string(data) != "deutsch" {
+		t.Errorf("repaired file = %q, %v", data, err)
+	}
+
+	state, err = readGameState(gamePath, &GameMeta{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := state.Items["/downloads/setup_de.exe"]
+	if item == nil || item.Version != "2" || item.Dir != "Deutsch" || item.Fname != "setup_de.exe" {
+		t.Errorf("state has %+v, want the repaired item recorded as version 2", item)
+	}
+}